@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/daemon"
+	"github.com/HeyRistaa/got/internal/localapi"
+)
+
+// runDaemonCommand handles `got daemon`, `got up` and `got down` and returns
+// the process exit code.
+func runDaemonCommand(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	configPath := fs.String("config", "", "tunnels config file (default: <user config dir>/got/config.json)")
+	var logPath *string
+	var systemd *bool
+	if cmd == "daemon" || cmd == "up" {
+		logPath = fs.String("log", "", "log file (default: log_file from config, else <user cache dir>/got/daemon.log for `up`)")
+	}
+	if cmd == "daemon" {
+		systemd = fs.Bool("systemd", false, "print a systemd user unit for the daemon and exit")
+	}
+	_ = fs.Parse(args)
+
+	if cmd == "down" {
+		if err := localapi.ShutdownDaemon(); err != nil {
+			colors.PrintfError("down: %v\n", err)
+			return 1
+		}
+		colors.PrintStop("Daemon stopped\n")
+		return 0
+	}
+
+	path := *configPath
+	if path == "" {
+		p, err := daemon.DefaultConfigPath()
+		if err != nil {
+			colors.PrintfError("%s: %v\n", cmd, err)
+			return 1
+		}
+		path = p
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	if systemd != nil && *systemd {
+		return printSystemdUnit(path)
+	}
+
+	cfg, err := daemon.LoadConfig(path)
+	if err != nil {
+		colors.PrintfError("%s: %v\n", cmd, err)
+		return 1
+	}
+	logFile := *logPath
+	if logFile == "" {
+		logFile = cfg.LogFile
+	}
+
+	if cmd == "up" {
+		return startDaemon(path, logFile)
+	}
+
+	if logFile != "" {
+		f, err := openLog(logFile)
+		if err != nil {
+			colors.PrintfError("daemon: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		os.Stdout, os.Stderr = f, f
+		colors.Output, colors.Enabled = f, false
+		if err := setDefaultLogger(f); err != nil {
+			colors.PrintfError("daemon: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	colors.PrintfRocket("Daemon starting %d tunnel(s) via %s\n", len(cfg.Tunnels), colors.Blue(cfg.Server))
+	if err := daemon.Run(ctx, cfg); err != nil {
+		colors.PrintfError("daemon: %v\n", err)
+		return 1
+	}
+	colors.PrintStop("Daemon stopped\n")
+	return 0
+}
+
+// startDaemon re-executes this binary as `got daemon` detached from the
+// terminal, with output going to the log file.
+func startDaemon(configPath, logFile string) int {
+	if localapi.DaemonRunning() {
+		colors.PrintInfo("Daemon already running\n")
+		return 0
+	}
+	if logFile == "" {
+		p, err := daemon.DefaultLogPath()
+		if err != nil {
+			colors.PrintfError("up: %v\n", err)
+			return 1
+		}
+		logFile = p
+	}
+	f, err := openLog(logFile)
+	if err != nil {
+		colors.PrintfError("up: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		colors.PrintfError("up: %v\n", err)
+		return 1
+	}
+	cmd := exec.Command(exe, "-log-level", logLevel, "-log-format", logFormat, "daemon", "-config", configPath)
+	cmd.Stdout, cmd.Stderr = f, f
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		colors.PrintfError("up: %v\n", err)
+		return 1
+	}
+	_ = cmd.Process.Release()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if localapi.DaemonRunning() {
+			colors.PrintfSuccess("Daemon started, logging to %s\n", colors.Cyan(logFile))
+			colors.PrintInfo("Use `got ls` to see tunnels and `got down` to stop\n")
+			return 0
+		}
+	}
+	colors.PrintfError("Daemon did not start, see %s\n", logFile)
+	return 1
+}
+
+func openLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+}
+
+func printSystemdUnit(configPath string) int {
+	exe, err := os.Executable()
+	if err != nil {
+		colors.PrintfError("daemon: %v\n", err)
+		return 1
+	}
+	if abs, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = abs
+	}
+	fmt.Printf(`# Save as ~/.config/systemd/user/got.service, then:
+#   systemctl --user daemon-reload
+#   systemctl --user enable --now got
+#   loginctl enable-linger $USER   # start at boot without logging in
+[Unit]
+Description=got tunnel daemon
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=%s daemon -config %s
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=default.target
+`, exe, configPath)
+	return 0
+}
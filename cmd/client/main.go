@@ -4,29 +4,115 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/localapi"
+	"github.com/HeyRistaa/got/internal/logging"
+	"github.com/HeyRistaa/got/internal/protocol/control"
+	"github.com/HeyRistaa/got/internal/tracing"
 	"github.com/HeyRistaa/got/internal/tunnel/client"
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 )
 
+// logLevel and logFormat hold the -log-level and -log-format flags, which
+// the daemon applies again once its log file is open.
+var logLevel, logFormat string
+
 func main() {
 	var server string
 	var local string
 	var id string
 	var domain string
+	var name string
+	var reconnect bool
+	var output string
+	var auth, token string
+	var allowCIDRs, denyCIDRs listFlag
+	var login bool
+	var loginDomains listFlag
+	var hostHeader string
+	var reqHeaders, respHeaders headerFlag
+	var rmReqHeaders, rmRespHeaders listFlag
+	var healthPath, healthStatus, healthAction string
+	var healthInterval time.Duration
+	var healthThreshold int
+	var otlpEndpoint string
+	var traceSample float64
+	var dashboard bool
 	flag.StringVar(&server, "server", "", "server host (port will be 4440)")
 	flag.StringVar(&local, "local", "", "local address to forward")
 	flag.StringVar(&id, "id", "", "client identifier")
 	flag.StringVar(&domain, "domain", "", "domain to use for the tunnel")
+	flag.StringVar(&token, "token", os.Getenv("GOT_TOKEN"), "API token presented to the server (default $GOT_TOKEN)")
+	flag.StringVar(&auth, "auth", "", `require visitors to log in with HTTP Basic auth, "user:pass"`)
+	flag.Var(&allowCIDRs, "allow-cidr", "only admit visitors from this CIDR or IP (repeatable)")
+	flag.Var(&denyCIDRs, "deny-cidr", "reject visitors from this CIDR or IP (repeatable)")
+	flag.BoolVar(&login, "login", false, "require visitors to log in with the server's identity provider (OIDC)")
+	flag.Var(&loginDomains, "login-domain", "with -login, only admit emails from this domain (repeatable)")
+	flag.StringVar(&output, "output", "text", `output format: "text" or "json" (newline-delimited events on stdout)`)
+	flag.BoolVar(&reconnect, "reconnect", false, "reconnect with backoff when the connection to the server drops (always on for daemon tunnels)")
+	flag.StringVar(&name, "name", "", "name for the tunnel in `got ls` (default: local address)")
+	flag.StringVar(&hostHeader, "host-header", "", `Host header sent to the local app ("rewrite" for the local address, or an explicit value)`)
+	flag.Var(&reqHeaders, "request-header", `add a request header, "Name: value" (repeatable)`)
+	flag.Var(&rmReqHeaders, "remove-request-header", "remove a request header (repeatable)")
+	flag.Var(&respHeaders, "response-header", `add a response header, "Name: value" (repeatable)`)
+	flag.Var(&rmRespHeaders, "remove-response-header", "remove a response header (repeatable)")
+	flag.StringVar(&healthPath, "health-path", "", `path the server probes through the tunnel, e.g. "/healthz" ("off" disables health checks)`)
+	flag.StringVar(&healthStatus, "health-status", "", `statuses counted as healthy, e.g. "200-299,404" (default: anything below 500)`)
+	flag.DurationVar(&healthInterval, "health-interval", 0, "time between health checks (default 2m)")
+	flag.IntVar(&healthThreshold, "health-threshold", 0, "consecutive failed health checks before -health-action applies (default 3)")
+	flag.StringVar(&healthAction, "health-action", "", `what to do when health checks keep failing: "warn" (default) or "close"`)
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", `export trace spans to this OTLP/HTTP collector, e.g. "http://localhost:4318"`)
+	flag.Float64Var(&traceSample, "trace-sample", 1, "fraction of traces started by this client to record, 0 to 1 (traces from the server follow its decision)")
+	flag.BoolVar(&dashboard, "tui", false, "show a full-screen dashboard of recent requests (treats traffic as HTTP)")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", logging.FormatText, "log output format: text or json")
 	flag.Parse()
 
+	if err := setDefaultLogger(os.Stderr); err != nil {
+		colors.PrintfError("%v\n", err)
+		os.Exit(2)
+	}
+
+	if auth != "" {
+		if user, _, ok := strings.Cut(auth, ":"); !ok || user == "" {
+			colors.PrintError(`invalid -auth (expected "user:pass")` + "\n")
+			os.Exit(2)
+		}
+	}
+
+	switch output {
+	case "text":
+	case "json":
+		// Keep stdout for events only; human-readable messages go to stderr
+		colors.Output, colors.Enabled = os.Stderr, false
+	default:
+		colors.PrintfError("invalid -output %q (expected text or json)\n", output)
+		os.Exit(2)
+	}
+	if dashboard && output == "json" {
+		colors.PrintError("-tui and -output json cannot be combined\n")
+		os.Exit(2)
+	}
+
+	// Subcommands that query running clients don't need a server
+	switch flag.Arg(0) {
+	case "status", "ls", "stop", "pause", "resume":
+		os.Exit(runLocalCommand(flag.Arg(0), flag.Args()[1:]))
+	case "daemon", "up", "down":
+		os.Exit(runDaemonCommand(flag.Arg(0), flag.Args()[1:]))
+	}
+
 	// Get server host - priority: CLI > env > Hetzner API
 	// Security: Do NOT hardcode production server IP in public repo
 	serverHost := ""
@@ -47,6 +133,16 @@ func main() {
 	controlAddr := fmt.Sprintf("%s:4440", serverHost)
 	dataAddr := fmt.Sprintf("%s:4441", serverHost)
 
+	// `got serve ./dist` starts an embedded file server and tunnels it
+	if local == "" && flag.Arg(0) == "serve" {
+		addr, err := startFileServer(flag.Args()[1:])
+		if err != nil {
+			colors.PrintfError("serve: %v\n", err)
+			os.Exit(1)
+		}
+		local = addr
+	}
+
 	// Positional arg convenience: `got 3002` or `got localhost:3002`
 	if local == "" && flag.NArg() >= 1 {
 		arg := flag.Arg(0)
@@ -65,20 +161,125 @@ func main() {
 		colors.PrintError("Usage: got -server <host> <localPort|host:port> [flags]\n")
 		colors.PrintInfo("Example: got -server your-server.com 3000\n")
 		colors.PrintInfo("Or set GOT_SERVER_HOST environment variable and use: got 3000\n")
+		colors.PrintInfo("To share a folder: got serve ./dist\n")
 		os.Exit(1)
 	}
 
 	colors.PrintRocket("Starting tunnel for " + colors.Cyan(local) + " via " + colors.Blue(controlAddr) + "\n")
 
 	c := client.New(controlAddr, dataAddr, local, id, domain)
+	c.Name = name
+	c.Reconnect = reconnect
+	c.Auth = auth
+	c.Token = token
+	c.AllowCIDRs, c.DenyCIDRs = allowCIDRs, denyCIDRs
+	c.Login = login || len(loginDomains) > 0
+	c.LoginEmailDomains = loginDomains
+	c.Logger = slog.Default()
+	if output == "json" {
+		c.Events = client.JSONEvents(os.Stdout)
+	}
+	if healthPath == "off" {
+		c.Health = &control.HealthPolicy{Disabled: true}
+	} else if healthPath != "" || healthStatus != "" || healthInterval > 0 || healthThreshold > 0 || healthAction != "" {
+		c.Health = &control.HealthPolicy{
+			Path:            healthPath,
+			ExpectStatus:    healthStatus,
+			IntervalSeconds: int(healthInterval / time.Second),
+			Threshold:       healthThreshold,
+			Action:          healthAction,
+		}
+	}
+	if hostHeader != "" || len(reqHeaders) > 0 || len(rmReqHeaders) > 0 || len(respHeaders) > 0 || len(rmRespHeaders) > 0 {
+		c.HTTP = &client.HTTPOptions{
+			HostHeader:      hostHeader,
+			RequestHeaders:  client.HeaderRules{Add: reqHeaders.Header(), Remove: rmReqHeaders},
+			ResponseHeaders: client.HeaderRules{Add: respHeaders.Header(), Remove: rmRespHeaders},
+		}
+	}
+	if otlpEndpoint != "" {
+		if traceSample < 0 || traceSample > 1 {
+			colors.PrintError("-trace-sample must be between 0 and 1\n")
+			os.Exit(1)
+		}
+		exporter := tracing.NewExporter(otlpEndpoint)
+		exporter.Logger = c.Logger
+		defer exporter.Shutdown()
+		c.Tracer = tracing.New("got-client", exporter, traceSample)
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+
+	// Expose the tunnel to `got status` / `got ls` / `got stop` / `got pause`
+	reg := localapi.NewRegistry()
+	if err := reg.Add(c, cancel); err != nil {
+		colors.PrintfWarning("Control socket unavailable: %v\n", err)
+	} else if ln, err := localapi.Listen(); err != nil {
+		colors.PrintfWarning("Control socket unavailable: %v\n", err)
+	} else {
+		defer ln.Close()
+		go func() { _ = localapi.Serve(ln, reg) }()
+	}
+
+	if dashboard {
+		os.Exit(runDashboard(ctx, cancel, c))
+	}
+
 	if err := c.Run(ctx); err != nil {
+		if c.Events != nil {
+			c.Events(client.Event{Time: time.Now(), Type: client.EventError, Name: c.Status().Name, Error: err.Error()})
+		}
 		colors.PrintfError("Client error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// setDefaultLogger makes a logger writing to w, configured by -log-level and
+// -log-format, the default for slog and the log package.
+func setDefaultLogger(w io.Writer) error {
+	logger, err := logging.New(w, logLevel, logFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// headerFlag collects repeatable "Name: value" flags.
+type headerFlag []string
+
+func (h *headerFlag) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlag) Set(v string) error {
+	if _, _, ok := client.ParseHeader(v); !ok {
+		return fmt.Errorf("expected \"Name: value\", got %q", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func (h headerFlag) Header() http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	hdr := make(http.Header)
+	for _, v := range h {
+		name, value, _ := client.ParseHeader(v)
+		hdr.Add(name, value)
+	}
+	return hdr
+}
+
+// listFlag collects a repeatable string flag.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ", ") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 // resolveHetznerIPFromEnv tries to read GOT_HC_TOKEN and either GOT_HC_SERVER_ID
 // or GOT_HC_SERVER_NAME to obtain the VM public IPv4 via Hetzner Cloud API.
 // Returns empty string on any failure or if envs are not set.
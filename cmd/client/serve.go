@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/fileserver"
+)
+
+// startFileServer handles `got serve [flags] <dir>`: it serves dir on an
+// ephemeral loopback port and returns that address for the tunnel to forward.
+func startFileServer(args []string) (string, error) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var opts fileserver.Options
+	fs.BoolVar(&opts.Listing, "listing", false, "show directory listings")
+	fs.BoolVar(&opts.SPA, "spa", false, "serve index.html for unknown paths (single-page apps)")
+	fs.BoolVar(&opts.Gzip, "gzip", true, "gzip compressible responses")
+	fs.BoolVar(&opts.Dotfiles, "dotfiles", false, "serve files and directories starting with a dot, such as .env and .git (hidden by default)")
+	fs.Usage = func() {
+		colors.PrintInfo("Usage: got serve [flags] <dir>\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("listen file server: %w", err)
+	}
+	go func() { _ = http.Serve(ln, fileserver.New(dir, opts)) }()
+
+	colors.PrintfInfo("Serving %s on %s\n", colors.Cyan(dir), colors.Cyan(ln.Addr().String()))
+	return ln.Addr().String(), nil
+}
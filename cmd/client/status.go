@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/humanize"
+	"github.com/HeyRistaa/got/internal/localapi"
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+)
+
+// runLocalCommand handles the subcommands that talk to running clients over
+// their control sockets and returns the process exit code.
+func runLocalCommand(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print JSON for scripting")
+	message := ""
+	if cmd == "pause" {
+		fs.StringVar(&message, "message", "", "shown to visitors on the paused page")
+	}
+	_ = fs.Parse(args)
+
+	switch cmd {
+	case "status", "ls":
+		sts, err := localapi.List()
+		if err != nil {
+			colors.PrintfError("%s: %v\n", cmd, err)
+			return 1
+		}
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(sts)
+			return 0
+		}
+		if len(sts) == 0 {
+			colors.PrintInfo("No tunnels running\n")
+			return 0
+		}
+		if cmd == "ls" {
+			printList(sts)
+		} else {
+			printStatus(sts)
+		}
+		return 0
+
+	case "stop":
+		if fs.NArg() != 1 {
+			colors.PrintError("Usage: got stop <name>\n")
+			return 1
+		}
+		name := fs.Arg(0)
+		err := localapi.Stop(name)
+		if *jsonOut {
+			res := map[string]any{"name": name, "stopped": err == nil}
+			if err != nil {
+				res["error"] = err.Error()
+			}
+			_ = json.NewEncoder(os.Stdout).Encode(res)
+		} else if err == nil {
+			colors.PrintfStop("Stopped %s\n", colors.Cyan(name))
+		} else {
+			colors.PrintfError("stop %s: %v\n", name, err)
+		}
+		if err != nil {
+			return 1
+		}
+		return 0
+
+	case "pause", "resume":
+		if fs.NArg() != 1 {
+			if cmd == "pause" {
+				colors.PrintError("Usage: got pause [-message text] <name>\n")
+			} else {
+				colors.PrintError("Usage: got resume <name>\n")
+			}
+			return 1
+		}
+		name := fs.Arg(0)
+		var err error
+		if cmd == "pause" {
+			err = localapi.Pause(name, message)
+		} else {
+			err = localapi.Resume(name)
+		}
+		if *jsonOut {
+			res := map[string]any{"name": name, "paused": cmd == "pause" && err == nil}
+			if err != nil {
+				res["error"] = err.Error()
+			}
+			_ = json.NewEncoder(os.Stdout).Encode(res)
+		} else if err != nil {
+			colors.PrintfError("%s %s: %v\n", cmd, name, err)
+		} else if cmd == "pause" {
+			colors.PrintfWarning("Paused %s: visitors get the maintenance page\n", colors.Cyan(name))
+		} else {
+			colors.PrintfSuccess("Resumed %s\n", colors.Cyan(name))
+		}
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+	return 2
+}
+
+func printList(sts []client.Status) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tLOCAL\tURL")
+	for _, st := range sts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", st.Name, st.State, st.LocalAddr, st.URL)
+	}
+	tw.Flush()
+}
+
+func printStatus(sts []client.Status) {
+	for i, st := range sts {
+		if i > 0 {
+			fmt.Println()
+		}
+		state := stateLabel(st.State)
+		if st.Paused {
+			state += " " + colors.Yellow("(paused)")
+		}
+		fmt.Printf("%s %s\n", colors.Bold(st.Name), state)
+		fmt.Printf("  URL:         %s\n", st.URL)
+		fmt.Printf("  Local:       %s\n", st.LocalAddr)
+		fmt.Printf("  Uptime:      %s\n", time.Since(st.StartedAt).Round(time.Second))
+		fmt.Printf("  Connections: %d active, %d total\n", st.ActiveConns, st.TotalConns)
+		fmt.Printf("  Traffic:     %s in, %s out\n", humanize.Bytes(st.BytesIn), humanize.Bytes(st.BytesOut))
+		switch st.Health {
+		case client.HealthHealthy:
+			fmt.Printf("  Health:      %s (checked %s ago)\n", colors.Green(st.Health), time.Since(st.HealthCheckedAt).Round(time.Second))
+		case client.HealthUnhealthy:
+			fmt.Printf("  Health:      %s: %s (checked %s ago)\n", colors.Red(st.Health), st.HealthError, time.Since(st.HealthCheckedAt).Round(time.Second))
+		}
+		switch st.Upstream {
+		case client.UpstreamUp:
+			fmt.Printf("  Local app:   %s\n", colors.Green(st.Upstream))
+		case client.UpstreamDown:
+			fmt.Printf("  Local app:   %s\n", colors.Red(st.Upstream))
+		}
+		if t := st.Traffic; t != nil {
+			last := ""
+			if t.LastVisitorIP != "" {
+				last = ", last from " + t.LastVisitorIP
+			}
+			fmt.Printf("  Visitors:    %d active, %d total, %d requests, %d errors%s\n", t.ActiveConns, t.TotalConns, t.Requests, t.Errors, last)
+		}
+		if st.LastError != "" {
+			fmt.Printf("  Last error:  %s (%s ago)\n", colors.Red(st.LastError), time.Since(st.LastErrorAt).Round(time.Second))
+		}
+	}
+}
+
+func stateLabel(state string) string {
+	switch state {
+	case client.StateOnline:
+		return colors.Green(state)
+	case client.StateConnecting:
+		return colors.Yellow(state)
+	default:
+		return colors.Red(state)
+	}
+}
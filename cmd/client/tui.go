@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/tui"
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+)
+
+// runDashboard runs c behind the full-screen dashboard until the user quits
+// or ctx is done, and returns the process exit code.
+func runDashboard(ctx context.Context, cancel context.CancelFunc, c *client.Client) int {
+	dash := tui.New(c)
+	c.Events = dash.Event
+	if c.HTTP == nil {
+		c.HTTP = &client.HTTPOptions{}
+	}
+	c.HTTP.Requests = dash.Request
+	c.PingInterval = 2 * time.Second
+	// Log lines would tear the screen; show them in its footer instead
+	if err := setDefaultLogger(dash); err != nil {
+		colors.PrintfError("%v\n", err)
+		return 2
+	}
+	c.Logger = nil
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Run(ctx)
+		cancel()
+	}()
+	if err := dash.Run(ctx, cancel); err != nil {
+		cancel()
+		<-errc
+		colors.PrintfError("%v\n", err)
+		return 1
+	}
+	if err := <-errc; err != nil {
+		colors.PrintfError("Client error: %v\n", err)
+		return 1
+	}
+	return 0
+}
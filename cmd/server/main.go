@@ -2,32 +2,131 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/logging"
+	"github.com/HeyRistaa/got/internal/oidc"
+	"github.com/HeyRistaa/got/internal/proxy/caddy"
+	"github.com/HeyRistaa/got/internal/tracing"
+	"github.com/HeyRistaa/got/internal/tunnel"
 	"github.com/HeyRistaa/got/internal/tunnel/server"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the server and returns the process exit code once it stops.
+// Exiting only in main lets the deferred closes flush the audit log, the
+// trace exporter and the admin API on every path.
+func run() int {
 	var publicIP string
 	var disableHealthCheck bool
+	var trustedProxies string
+	var oidcIssuer, oidcClientID, oidcDomains string
+	var limitControl, limitTunnels, limitVisitors string
+	var tunnelRate, clientRate, dailyQuota, monthlyQuota string
+	var quotaAction, usageFile string
+	var maxTunnelConns, maxClientConns, connQueue int
+	var connQueueTimeout time.Duration
+	var handshakeTimeout, idleTimeout, maxConnLifetime, keepAlive time.Duration
+	var banFile, adminAddr, phishingPaths string
+	var abuseBan time.Duration
+	var abuseRateLimit, abuseFailedAuth, abuseShortLived int
+	var logLevel, logFormat string
+	var accessLog, accessLogFormat, accessLogMaxSize string
+	var accessLogBackups int
+	var auditLog, auditLogMaxSize string
+	var auditLogBackups int
+	var webhooks webhookFlag
+	var webhookDeadLetter string
+	var webhookAttempts int
+	var otlpEndpoint string
+	var traceSample float64
+	var traceInject bool
+	var statsInterval time.Duration
+	var maintenancePage string
+	var maintenanceStatus int
 	flag.StringVar(&publicIP, "public", "", "public IP/host advertised for tunnels")
 	flag.BoolVar(&disableHealthCheck, "disable-health-check", false, "disable health checks for tunnels")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "127.0.0.0/8,::1/128", "comma-separated CIDRs whose X-Forwarded-For is trusted (Caddy)")
+	flag.StringVar(&oidcIssuer, "oidc-issuer", "", "OpenID Connect issuer URL for tunnels that request a login gate")
+	flag.StringVar(&oidcClientID, "oidc-client-id", "", "OAuth client ID registered with the issuer")
+	flag.StringVar(&oidcDomains, "oidc-email-domains", "", "comma-separated email domains allowed to log in (default: any)")
+	flag.StringVar(&limitControl, "limit-control", "10/20s", `control connections per client IP, e.g. "10/20s" ("off" to disable)`)
+	flag.StringVar(&limitTunnels, "limit-tunnels", "5/1m,20/1h", "tunnels opened per client IP and per token")
+	flag.StringVar(&limitVisitors, "limit-visitors", "200/1s", "visitor connections (or HTTP requests) per tunnel per visitor IP")
+	flag.StringVar(&tunnelRate, "tunnel-rate", "", `bandwidth per tunnel and direction, per second, e.g. "1MB" (default: unlimited)`)
+	flag.StringVar(&clientRate, "client-rate", "", "bandwidth shared by all tunnels of a client, per direction, per second")
+	flag.StringVar(&dailyQuota, "daily-quota", "", `bytes a client may transfer per UTC day, e.g. "5GB"`)
+	flag.StringVar(&monthlyQuota, "monthly-quota", "", "bytes a client may transfer per UTC month")
+	flag.StringVar(&quotaAction, "quota-action", server.QuotaClose, `what to do when a quota is used up: "close" or "pause"`)
+	flag.StringVar(&usageFile, "usage-file", "", "file to persist quota usage across restarts")
+	flag.IntVar(&maxTunnelConns, "max-tunnel-conns", 0, "concurrent visitor connections (or HTTP requests) per tunnel (default: unlimited)")
+	flag.IntVar(&maxClientConns, "max-client-conns", 0, "concurrent visitor connections across all tunnels of a client (default: unlimited)")
+	flag.IntVar(&connQueue, "conn-queue", 64, "visitors that may wait for a slot on a saturated tunnel")
+	flag.DurationVar(&connQueueTimeout, "conn-queue-timeout", 10*time.Second, "how long a queued visitor waits before being turned away (0 disables queueing)")
+	flag.DurationVar(&handshakeTimeout, "handshake-timeout", 10*time.Second, "time allowed for open_tunnel, data_init and HTTP request headers (0 disables)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 0, "close visitor connections idle in both directions for this long (0 disables)")
+	flag.DurationVar(&maxConnLifetime, "max-conn-lifetime", 0, "close visitor connections after this long regardless of activity (default: no limit)")
+	flag.DurationVar(&keepAlive, "tcp-keepalive", 30*time.Second, "TCP keep-alive period for accepted connections (negative disables)")
+	flag.StringVar(&banFile, "ban-file", "", "file to persist the ban list in (default: in memory only)")
+	flag.StringVar(&adminAddr, "admin", "", "address for the admin API, e.g. 127.0.0.1:4442 (token from GOT_ADMIN_TOKEN)")
+	flag.DurationVar(&abuseBan, "abuse-ban-duration", time.Hour, "how long automatic bans last")
+	flag.IntVar(&abuseRateLimit, "abuse-rate-limit", 50, "auto-ban an IP after this many rate-limit violations in 10 minutes (0 disables)")
+	flag.IntVar(&abuseFailedAuth, "abuse-failed-auth", 100, "auto-ban an IP after this many failed visitor or admin logins in 10 minutes (0 disables)")
+	flag.IntVar(&abuseShortLived, "abuse-short-lived", 30, "auto-ban a client IP after this many tunnels closed within 10s of opening in 10 minutes (0 disables)")
+	flag.StringVar(&phishingPaths, "phishing-paths", "", `comma-separated path patterns (e.g. "/wp-admin/*") that get a tunnel flagged for review when answered with 2xx (HTTP edge tunnels only)`)
+	flag.StringVar(&logLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log-format", logging.FormatText, "log output format: text or json")
+	flag.StringVar(&accessLog, "access-log", "", `file to log visitor HTTP requests to ("-" for stdout); covers tunnels served through the HTTP edge`)
+	flag.StringVar(&accessLogFormat, "access-log-format", server.AccessCombined, "access log format: common, combined or json")
+	flag.StringVar(&accessLogMaxSize, "access-log-max-size", "100MB", "rotate the access log once it reaches this size")
+	flag.IntVar(&accessLogBackups, "access-log-backups", 5, "rotated access logs to keep")
+	flag.StringVar(&auditLog, "audit-log", "", "JSONL file recording tunnel opens and closes, auth failures, rate-limit denials and admin actions")
+	flag.StringVar(&auditLogMaxSize, "audit-log-max-size", "100MB", "rotate the audit log once it reaches this size")
+	flag.IntVar(&auditLogBackups, "audit-log-backups", 10, "rotated audit logs to keep (and search through /v1/audit)")
+	flag.Var(&webhooks, "webhook", `POST tunnel events to this URL, optionally followed by the events to send, e.g. "https://chat.example.com/hook tunnel.closed health.failed" (repeatable; secret from GOT_WEBHOOK_SECRET)`)
+	flag.StringVar(&webhookDeadLetter, "webhook-dead-letter", "", "file to append webhook events that could not be delivered to")
+	flag.IntVar(&webhookAttempts, "webhook-attempts", 5, "delivery attempts per webhook event")
+	flag.DurationVar(&statsInterval, "stats-interval", 2*time.Second, "how often clients are sent live traffic counters (0 to disable)")
+	flag.StringVar(&maintenancePage, "maintenance-page", "", "file served to visitors of paused tunnels instead of the built-in page")
+	flag.IntVar(&maintenanceStatus, "maintenance-status", http.StatusServiceUnavailable, "HTTP status for visitors of paused tunnels")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", `export trace spans to this OTLP/HTTP collector, e.g. "http://localhost:4318"`)
+	flag.Float64Var(&traceSample, "trace-sample", 1, "fraction of new traces to record, 0 to 1")
+	flag.BoolVar(&traceInject, "trace-inject", false, "add a traceparent header to requests proxied through the HTTP edge")
 	flag.Parse()
 
+	logger, err := logging.New(os.Stderr, logLevel, logFormat)
+	if err != nil {
+		colors.PrintfError("%v\n", err)
+		return 1
+	}
+	slog.SetDefault(logger)
+
 	if publicIP == "" {
 		colors.PrintInfo("Detecting public IP...\n")
 		publicIP = detectPublicIP()
 	}
 	if publicIP == "" {
 		colors.PrintError("Could not detect public IP, please provide it with the -public flag\n")
-		os.Exit(1)
+		return 1
 	}
 	colors.PrintfInfo("Public IP: %s\n", colors.Bold(colors.BrightCyan(publicIP)))
 
@@ -46,14 +145,242 @@ func main() {
 	colors.PrintfInfo("Data port: %s\n", colors.Bold(colors.Blue(dataAddr)))
 	colors.PrintSuccess("Server is ready to accept connections!\n")
 
-	srv := server.New(controlAddr, dataAddr, publicIP)
+	routes := caddy.New("http://127.0.0.1:2019")
+	routes.Logger = logger
+	manager := tunnel.NewManagerWithRoutes(routes)
+	manager.Logger = logger
+	srv := server.NewWithManager(controlAddr, dataAddr, publicIP, manager)
+	srv.Logger = logger
+	trusted, err := server.ParsePrefixes(strings.Split(trustedProxies, ","))
+	if err != nil {
+		colors.PrintfError("Invalid -trusted-proxies: %v\n", err)
+		return 1
+	}
+	srv.TrustedProxies = trusted
+
+	for _, l := range []struct {
+		flag, value string
+		dst         *[]server.Limit
+	}{
+		{"limit-control", limitControl, &srv.RateLimits.ControlConnects},
+		{"limit-tunnels", limitTunnels, &srv.RateLimits.TunnelCreates},
+		{"limit-visitors", limitVisitors, &srv.RateLimits.VisitorConns},
+	} {
+		limits, err := server.ParseLimits(l.value)
+		if err != nil {
+			colors.PrintfError("Invalid -%s: %v\n", l.flag, err)
+			return 1
+		}
+		*l.dst = limits
+	}
+
+	if quotaAction != server.QuotaClose && quotaAction != server.QuotaPause {
+		colors.PrintfError("Invalid -quota-action %q (expected close or pause)\n", quotaAction)
+		return 1
+	}
+	bans, err := server.LoadBanList(banFile)
+	if err != nil {
+		colors.PrintfError("Invalid -ban-file: %v\n", err)
+		return 1
+	}
+	srv.Bans = bans
+	srv.Abuse.BanDuration = abuseBan
+	srv.Abuse.RateLimitViolations = abuseRateLimit
+	srv.Abuse.FailedAuth = abuseFailedAuth
+	srv.Abuse.ShortLivedTunnels = abuseShortLived
+	if phishingPaths != "" {
+		srv.Abuse.PhishingPaths = strings.Split(phishingPaths, ",")
+	}
+
+	srv.Timeouts = server.Timeouts{
+		Handshake:   handshakeTimeout,
+		Idle:        idleTimeout,
+		MaxLifetime: maxConnLifetime,
+		KeepAlive:   keepAlive,
+	}
+	srv.Concurrency = server.Concurrency{
+		PerTunnel:    maxTunnelConns,
+		PerClient:    maxClientConns,
+		QueueSize:    connQueue,
+		QueueTimeout: connQueueTimeout,
+	}
+	srv.Bandwidth.QuotaAction = quotaAction
+	srv.Bandwidth.UsageFile = usageFile
+	for _, b := range []struct {
+		flag, value string
+		dst         *int64
+	}{
+		{"tunnel-rate", tunnelRate, &srv.Bandwidth.TunnelRate},
+		{"client-rate", clientRate, &srv.Bandwidth.ClientRate},
+		{"daily-quota", dailyQuota, &srv.Bandwidth.DailyQuota},
+		{"monthly-quota", monthlyQuota, &srv.Bandwidth.MonthlyQuota},
+	} {
+		n, err := server.ParseBytes(b.value)
+		if err != nil {
+			colors.PrintfError("Invalid -%s: %v\n", b.flag, err)
+			return 1
+		}
+		*b.dst = n
+	}
+
+	switch accessLogFormat {
+	case server.AccessCommon, server.AccessCombined, server.AccessJSON:
+	default:
+		colors.PrintfError("Invalid -access-log-format %q (expected common, combined or json)\n", accessLogFormat)
+		return 1
+	}
+	srv.AccessLog.Format = accessLogFormat
+	switch accessLog {
+	case "":
+	case "-":
+		srv.AccessLog.Writer = os.Stdout
+	default:
+		maxSize, err := server.ParseBytes(accessLogMaxSize)
+		if err != nil {
+			colors.PrintfError("Invalid -access-log-max-size: %v\n", err)
+			return 1
+		}
+		f, err := logging.OpenRotating(accessLog, maxSize, accessLogBackups)
+		if err != nil {
+			colors.PrintfError("Access log: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		srv.AccessLog.Writer = f
+	}
+
+	if auditLog != "" {
+		maxSize, err := server.ParseBytes(auditLogMaxSize)
+		if err != nil {
+			colors.PrintfError("Invalid -audit-log-max-size: %v\n", err)
+			return 1
+		}
+		audit, err := server.OpenAuditLog(auditLog, maxSize, auditLogBackups)
+		if err != nil {
+			colors.PrintfError("Audit log: %v\n", err)
+			return 1
+		}
+		defer audit.Close()
+		srv.Audit = audit
+	}
+
+	// The signing secret comes from the environment to keep it out of `ps`
+	secret := os.Getenv("GOT_WEBHOOK_SECRET")
+	for _, w := range webhooks {
+		w.Secret = secret
+		srv.Webhooks.Endpoints = append(srv.Webhooks.Endpoints, w)
+	}
+	srv.Webhooks.DeadLetterFile = webhookDeadLetter
+	srv.Webhooks.MaxAttempts = webhookAttempts
+	srv.StatsInterval = statsInterval
+
+	if http.StatusText(maintenanceStatus) == "" {
+		colors.PrintfError("Invalid -maintenance-status %d\n", maintenanceStatus)
+		return 1
+	}
+	srv.Maintenance.Status = maintenanceStatus
+	if maintenancePage != "" {
+		body, err := os.ReadFile(maintenancePage)
+		if err != nil {
+			colors.PrintfError("Maintenance page: %v\n", err)
+			return 1
+		}
+		srv.Maintenance.Body = body
+		if srv.Maintenance.ContentType = mime.TypeByExtension(filepath.Ext(maintenancePage)); srv.Maintenance.ContentType == "" {
+			srv.Maintenance.ContentType = http.DetectContentType(body)
+		}
+	}
+
+	if otlpEndpoint != "" {
+		if traceSample < 0 || traceSample > 1 {
+			colors.PrintError("-trace-sample must be between 0 and 1\n")
+			return 1
+		}
+		exporter := tracing.NewExporter(otlpEndpoint)
+		exporter.Logger = logger
+		defer exporter.Shutdown()
+		srv.Tracer = tracing.New("got-server", exporter, traceSample)
+		srv.TraceInject = traceInject
+		colors.PrintfInfo("Exporting traces to %s\n", colors.Bold(otlpEndpoint))
+	} else if traceInject {
+		colors.PrintWarning("-trace-inject has no effect without -otlp-endpoint\n")
+	}
+
+	if oidcIssuer != "" {
+		// Secrets come from the environment to keep them out of `ps`
+		cfg := oidc.Config{
+			Issuer:       oidcIssuer,
+			ClientID:     oidcClientID,
+			ClientSecret: os.Getenv("GOT_OIDC_CLIENT_SECRET"),
+			CookieSecret: []byte(os.Getenv("GOT_OIDC_COOKIE_SECRET")),
+		}
+		if oidcDomains != "" {
+			cfg.EmailDomains = strings.Split(oidcDomains, ",")
+		}
+		provider, err := oidc.New(cfg)
+		if err != nil {
+			colors.PrintfError("Invalid OIDC configuration: %v\n", err)
+			return 1
+		}
+		srv.OIDC = provider
+		colors.PrintfInfo("Login gate available via %s\n", colors.Bold(oidcIssuer))
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	if adminAddr != "" {
+		// The token comes from the environment to keep it out of `ps`
+		token := os.Getenv("GOT_ADMIN_TOKEN")
+		if token == "" {
+			colors.PrintError("-admin requires GOT_ADMIN_TOKEN to be set\n")
+			return 1
+		}
+		ln, err := net.Listen("tcp", adminAddr)
+		if err != nil {
+			colors.PrintfError("Admin API: %v\n", err)
+			return 1
+		}
+		admin := &http.Server{Handler: srv.AdminHandler(token), ReadHeaderTimeout: 10 * time.Second}
+		go admin.Serve(ln)
+		defer admin.Close()
+		colors.PrintfInfo("Admin API: %s\n", colors.Bold(colors.Blue(adminAddr)))
+	}
+
 	if err := srv.Run(ctx); err != nil {
 		colors.PrintfError("Server error: %v\n", err)
-		os.Exit(1)
+		return 1
+	}
+	return 0
+}
+
+// webhookFlag collects repeatable -webhook values: a URL followed by
+// optional event types.
+type webhookFlag []server.Webhook
+
+func (f *webhookFlag) String() string {
+	urls := make([]string, len(*f))
+	for i, w := range *f {
+		urls[i] = w.URL
+	}
+	return strings.Join(urls, ", ")
+}
+
+func (f *webhookFlag) Set(v string) error {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return errors.New("empty webhook")
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", fields[0])
+	}
+	for _, ev := range fields[1:] {
+		if !slices.Contains(server.WebhookEventTypes, ev) {
+			return fmt.Errorf("unknown webhook event %q (expected one of %s)", ev, strings.Join(server.WebhookEventTypes, ", "))
+		}
 	}
+	*f = append(*f, server.Webhook{URL: fields[0], Events: fields[1:]})
+	return nil
 }
 
 func detectPublicIP() string {
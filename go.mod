@@ -4,7 +4,10 @@ go 1.25
 
 toolchain go1.25.3
 
-require github.com/hetznercloud/hcloud-go/v2 v2.28.0
+require (
+	github.com/hetznercloud/hcloud-go/v2 v2.28.0
+	golang.org/x/sys v0.37.0
+)
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
@@ -16,7 +19,6 @@ require (
 	github.com/prometheus/procfs v0.18.0 // indirect
 	go.yaml.in/yaml/v2 v2.4.3 // indirect
 	golang.org/x/net v0.46.0 // indirect
-	golang.org/x/sys v0.37.0 // indirect
 	golang.org/x/text v0.30.0 // indirect
 	google.golang.org/protobuf v1.36.10 // indirect
 )
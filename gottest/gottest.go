@@ -0,0 +1,195 @@
+// Package gottest runs a got server and tunnel in-process for hermetic
+// end-to-end tests. Everything binds to ephemeral loopback ports and routes
+// are recorded in memory instead of being pushed to Caddy, so no network
+// access or reverse proxy is needed.
+//
+//	func TestWebhook(t *testing.T) {
+//		tun := gottest.Start(t, myHandler)
+//		resp, err := http.Post(tun.URL+"/webhook", "application/json", body)
+//		...
+//	}
+package gottest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/tunnel"
+	"github.com/HeyRistaa/got/internal/tunnel/server"
+	gottunnel "github.com/HeyRistaa/got/tunnel"
+)
+
+// Routes is an in-memory route provider standing in for Caddy
+type Routes struct {
+	mu     sync.Mutex
+	routes map[string]int // host -> public port
+}
+
+// AddRoute records host -> port.
+func (r *Routes) AddRoute(host string, port int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.routes == nil {
+		r.routes = make(map[string]int)
+	}
+	r.routes[host] = port
+	return nil
+}
+
+// DeleteRouteByHost forgets host.
+func (r *Routes) DeleteRouteByHost(host string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.routes, host)
+	return nil
+}
+
+// Lookup returns the public port routed for host.
+func (r *Routes) Lookup(host string) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	port, ok := r.routes[host]
+	return port, ok
+}
+
+// Len returns the number of active routes.
+func (r *Routes) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.routes)
+}
+
+// Server is an in-process got server listening on loopback
+type Server struct {
+	ControlAddr string // host:port for client control connections
+	DataAddr    string // host:port for client data connections
+	Routes      *Routes
+	Server      *server.Server
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewServer starts a server on ephemeral loopback ports. Health checks are
+// disabled since there is no public endpoint to poll. Each configure func
+// runs before the server starts serving, e.g. to set rate limits or bans.
+func NewServer(configure ...func(*server.Server)) (*Server, error) {
+	ctlLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("gottest: listen control: %w", err)
+	}
+	dataLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		ctlLn.Close()
+		return nil, fmt.Errorf("gottest: listen data: %w", err)
+	}
+
+	routes := &Routes{}
+	m := tunnel.NewManagerWithRoutes(routes)
+	m.DisableHealthCheck = true
+	srv := server.NewWithManager(ctlLn.Addr().String(), dataLn.Addr().String(), "127.0.0.1", m)
+	srv.RateLimits = server.RateLimits{} // tests open tunnels in quick succession
+	srv.Abuse = server.AbuseRules{}
+	for _, fn := range configure {
+		fn(srv)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		ControlAddr: ctlLn.Addr().String(),
+		DataAddr:    dataLn.Addr().String(),
+		Routes:      routes,
+		Server:      srv,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		_ = srv.Serve(ctx, ctlLn, dataLn)
+	}()
+	return s, nil
+}
+
+// Close stops the server and every tunnel opened on it.
+func (s *Server) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// Open connects a tunnel client to the server and serves h on it.
+func (s *Server) Open(h http.Handler) (*Tunnel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ln, err := gottunnel.Listen(ctx, gottunnel.Config{
+		ControlAddr: s.ControlAddr,
+		DataAddr:    s.DataAddr,
+		ClientID:    "gottest",
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := &Tunnel{
+		URL:      "http://" + ln.Addr().String(),
+		Host:     ln.PublicHost(),
+		TunnelID: ln.TunnelID(),
+		ln:       ln,
+		srv:      &http.Server{Handler: h},
+		served:   make(chan struct{}),
+	}
+	go func() {
+		defer close(t.served)
+		_ = t.srv.Serve(ln)
+	}()
+	return t, nil
+}
+
+// Tunnel is an open tunnel serving an http.Handler
+type Tunnel struct {
+	// URL reaches the tunnel's public port directly, e.g. http://127.0.0.1:41234.
+	URL string
+	// Host is the public hostname the server assigned (routed only in Routes).
+	Host     string
+	TunnelID string
+
+	ln     *gottunnel.Listener
+	srv    *http.Server
+	served chan struct{}
+	server *Server // set when Start created the server
+}
+
+// Close shuts the tunnel down, and its server if Start created one.
+func (t *Tunnel) Close() error {
+	err := t.srv.Close()
+	<-t.served
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		err = t.ln.Close()
+	}
+	if t.server != nil {
+		t.server.Close()
+	}
+	return err
+}
+
+// Start starts a dedicated server, opens a tunnel serving h and registers
+// cleanup with tb. It fails the test on any setup error.
+func Start(tb testing.TB, h http.Handler) *Tunnel {
+	tb.Helper()
+	srv, err := NewServer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	t, err := srv.Open(h)
+	if err != nil {
+		srv.Close()
+		tb.Fatal(err)
+	}
+	t.server = srv
+	tb.Cleanup(func() { _ = t.Close() })
+	return t
+}
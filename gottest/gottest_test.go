@@ -0,0 +1,58 @@
+package gottest
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStart(t *testing.T) {
+	tun := Start(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	}))
+
+	resp, err := http.Get(tun.URL + "/world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello /world" {
+		t.Fatalf("GET = %d %q, want 200 %q", resp.StatusCode, body, "hello /world")
+	}
+	if tun.TunnelID == "" {
+		t.Error("TunnelID is empty")
+	}
+}
+
+func TestRoutesFollowTunnels(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	tun, err := srv.Open(http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Routes.Lookup(tun.Host); !ok || srv.Routes.Len() != 1 {
+		t.Fatalf("route for %q not recorded (%d routes)", tun.Host, srv.Routes.Len())
+	}
+	if err := tun.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return srv.Routes.Len() == 0 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,6 +1,43 @@
 package colors
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Enabled controls whether ANSI escape codes are emitted. It defaults to off
+// when NO_COLOR is set, TERM is "dumb" or stdout is not a terminal.
+var Enabled = detect()
+
+// Output is where the Print* helpers write. Switching it to os.Stderr keeps
+// stdout clean for machine-readable output.
+var Output io.Writer = os.Stdout
+
+// status is the live status line, see SetStatusLine
+var status struct {
+	mu   sync.Mutex
+	line string
+}
+
+func detect() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func paint(code, s string) string {
+	if !Enabled {
+		return s
+	}
+	return code + s + AnsiReset
+}
 
 // ANSI color codes
 const (
@@ -25,26 +62,26 @@ const (
 )
 
 // Color functions
-func Red(s string) string          { return AnsiRed + s + AnsiReset }
-func Green(s string) string        { return AnsiGreen + s + AnsiReset }
-func Yellow(s string) string       { return AnsiYellow + s + AnsiReset }
-func Blue(s string) string         { return AnsiBlue + s + AnsiReset }
-func Purple(s string) string       { return AnsiPurple + s + AnsiReset }
-func Cyan(s string) string         { return AnsiCyan + s + AnsiReset }
-func White(s string) string        { return AnsiWhite + s + AnsiReset }
-func Gray(s string) string         { return AnsiGray + s + AnsiReset }
-func BrightRed(s string) string    { return AnsiBrightRed + s + AnsiReset }
-func BrightGreen(s string) string  { return AnsiBrightGreen + s + AnsiReset }
-func BrightYellow(s string) string { return AnsiBrightYellow + s + AnsiReset }
-func BrightBlue(s string) string   { return AnsiBrightBlue + s + AnsiReset }
-func BrightPurple(s string) string { return AnsiBrightPurple + s + AnsiReset }
-func BrightCyan(s string) string   { return AnsiBrightCyan + s + AnsiReset }
-func BrightWhite(s string) string  { return AnsiBrightWhite + s + AnsiReset }
+func Red(s string) string          { return paint(AnsiRed, s) }
+func Green(s string) string        { return paint(AnsiGreen, s) }
+func Yellow(s string) string       { return paint(AnsiYellow, s) }
+func Blue(s string) string         { return paint(AnsiBlue, s) }
+func Purple(s string) string       { return paint(AnsiPurple, s) }
+func Cyan(s string) string         { return paint(AnsiCyan, s) }
+func White(s string) string        { return paint(AnsiWhite, s) }
+func Gray(s string) string         { return paint(AnsiGray, s) }
+func BrightRed(s string) string    { return paint(AnsiBrightRed, s) }
+func BrightGreen(s string) string  { return paint(AnsiBrightGreen, s) }
+func BrightYellow(s string) string { return paint(AnsiBrightYellow, s) }
+func BrightBlue(s string) string   { return paint(AnsiBrightBlue, s) }
+func BrightPurple(s string) string { return paint(AnsiBrightPurple, s) }
+func BrightCyan(s string) string   { return paint(AnsiBrightCyan, s) }
+func BrightWhite(s string) string  { return paint(AnsiBrightWhite, s) }
 
 // Special formatting
-func Bold(s string) string      { return "\033[1m" + s + AnsiReset }
-func Italic(s string) string    { return "\033[3m" + s + AnsiReset }
-func Underline(s string) string { return "\033[4m" + s + AnsiReset }
+func Bold(s string) string      { return paint("\033[1m", s) }
+func Italic(s string) string    { return paint("\033[3m", s) }
+func Underline(s string) string { return paint("\033[4m", s) }
 
 // Predefined styled messages
 func Success(s string) string { return Green("✅ " + s) }
@@ -58,23 +95,48 @@ func Check(s string) string   { return Green("✓ " + s) }
 func Cross(s string) string   { return Red("✗ " + s) }
 
 // Print functions
-func PrintSuccess(s string) { fmt.Print(Success(s)) }
-func PrintError(s string)   { fmt.Print(Error(s)) }
-func PrintWarning(s string) { fmt.Print(Warning(s)) }
-func PrintInfo(s string)    { fmt.Print(Info(s)) }
-func PrintRocket(s string)  { fmt.Print(Rocket(s)) }
-func PrintGlobe(s string)   { fmt.Print(Globe(s)) }
-func PrintStop(s string)    { fmt.Print(Stop(s)) }
-func PrintCheck(s string)   { fmt.Print(Check(s)) }
-func PrintCross(s string)   { fmt.Print(Cross(s)) }
+func PrintSuccess(s string) { write(Success(s)) }
+func PrintError(s string)   { write(Error(s)) }
+func PrintWarning(s string) { write(Warning(s)) }
+func PrintInfo(s string)    { write(Info(s)) }
+func PrintRocket(s string)  { write(Rocket(s)) }
+func PrintGlobe(s string)   { write(Globe(s)) }
+func PrintStop(s string)    { write(Stop(s)) }
+func PrintCheck(s string)   { write(Check(s)) }
+func PrintCross(s string)   { write(Cross(s)) }
 
 // Printf functions
-func PrintfSuccess(format string, args ...interface{}) { fmt.Printf(Success(format), args...) }
-func PrintfError(format string, args ...interface{})   { fmt.Printf(Error(format), args...) }
-func PrintfWarning(format string, args ...interface{}) { fmt.Printf(Warning(format), args...) }
-func PrintfInfo(format string, args ...interface{})    { fmt.Printf(Info(format), args...) }
-func PrintfRocket(format string, args ...interface{})  { fmt.Printf(Rocket(format), args...) }
-func PrintfGlobe(format string, args ...interface{})   { fmt.Printf(Globe(format), args...) }
-func PrintfStop(format string, args ...interface{})    { fmt.Printf(Stop(format), args...) }
-func PrintfCheck(format string, args ...interface{})   { fmt.Printf(Check(format), args...) }
-func PrintfCross(format string, args ...interface{})   { fmt.Printf(Cross(format), args...) }
+func PrintfSuccess(format string, args ...interface{}) { write(fmt.Sprintf(Success(format), args...)) }
+func PrintfError(format string, args ...interface{})   { write(fmt.Sprintf(Error(format), args...)) }
+func PrintfWarning(format string, args ...interface{}) { write(fmt.Sprintf(Warning(format), args...)) }
+func PrintfInfo(format string, args ...interface{})    { write(fmt.Sprintf(Info(format), args...)) }
+func PrintfRocket(format string, args ...interface{})  { write(fmt.Sprintf(Rocket(format), args...)) }
+func PrintfGlobe(format string, args ...interface{})   { write(fmt.Sprintf(Globe(format), args...)) }
+func PrintfStop(format string, args ...interface{})    { write(fmt.Sprintf(Stop(format), args...)) }
+func PrintfCheck(format string, args ...interface{})   { write(fmt.Sprintf(Check(format), args...)) }
+func PrintfCross(format string, args ...interface{})   { write(fmt.Sprintf(Cross(format), args...)) }
+
+// SetStatusLine shows s on the last line of the terminal in place of the
+// previous status line; the Print helpers write above it. An empty s removes
+// the line. It does nothing when colors are disabled, since redrawing needs
+// escape codes.
+func SetStatusLine(s string) {
+	if !Enabled {
+		return
+	}
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	fmt.Fprint(Output, "\r\033[K"+s)
+	status.line = s
+}
+
+// write prints s to Output, keeping the status line below it.
+func write(s string) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.line == "" {
+		fmt.Fprint(Output, s)
+		return
+	}
+	fmt.Fprint(Output, "\r\033[K"+s+status.line)
+}
@@ -0,0 +1,202 @@
+// Package daemon runs a set of long-lived tunnels defined in a config file.
+// It backs `got daemon`, which `got up` starts in the background.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/HeyRistaa/got/internal/localapi"
+	"github.com/HeyRistaa/got/internal/protocol/control"
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+)
+
+// Config is the daemon config file, by default <user config dir>/got/config.json:
+//
+//	{
+//	  "server": "tunnel.example.com",
+//	  "tunnels": [
+//	    {"name": "api", "local": "3000"},
+//	    {"name": "web", "local": "localhost:5173", "host_header": "rewrite"}
+//	  ]
+//	}
+type Config struct {
+	Server  string         `json:"server"`   // server host; GOT_SERVER_HOST is used when empty
+	LogFile string         `json:"log_file"` // optional; defaults to DefaultLogPath
+	Tunnels []TunnelConfig `json:"tunnels"`
+}
+
+// TunnelConfig describes one tunnel owned by the daemon
+type TunnelConfig struct {
+	Name     string `json:"name"`
+	Local    string `json:"local"` // port or host:port
+	ClientID string `json:"client_id,omitempty"`
+	Token    string `json:"token,omitempty"` // API token; GOT_TOKEN is used when empty
+	Auth     string `json:"auth,omitempty"`  // "user:pass" for HTTP Basic auth at the server
+
+	AllowCIDRs []string `json:"allow_cidrs,omitempty"`
+	DenyCIDRs  []string `json:"deny_cidrs,omitempty"`
+
+	Login        bool     `json:"login,omitempty"` // OIDC login gate at the server
+	LoginDomains []string `json:"login_domains,omitempty"`
+
+	// Optional health check policy for the server, e.g.
+	// {"path": "/healthz", "expect_status": "2xx", "action": "close"}
+	Health *control.HealthPolicy `json:"health,omitempty"`
+
+	// Optional HTTP-aware forwarding, see client.HTTPOptions
+	HostHeader            string            `json:"host_header,omitempty"`
+	RequestHeaders        map[string]string `json:"request_headers,omitempty"`
+	RemoveRequestHeaders  []string          `json:"remove_request_headers,omitempty"`
+	ResponseHeaders       map[string]string `json:"response_headers,omitempty"`
+	RemoveResponseHeaders []string          `json:"remove_response_headers,omitempty"`
+}
+
+// DefaultConfigPath returns <user config dir>/got/config.json.
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "got", "config.json"), nil
+}
+
+// DefaultLogPath returns <user cache dir>/got/daemon.log.
+func DefaultLogPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "got", "daemon.log"), nil
+}
+
+// LoadConfig reads and validates a config file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.Server == "" {
+		cfg.Server = os.Getenv("GOT_SERVER_HOST")
+	}
+	if cfg.Server == "" {
+		return nil, errors.New("no server configured (set \"server\" or GOT_SERVER_HOST)")
+	}
+	if len(cfg.Tunnels) == 0 {
+		return nil, errors.New("no tunnels configured")
+	}
+	seen := make(map[string]bool)
+	for i := range cfg.Tunnels {
+		t := &cfg.Tunnels[i]
+		local, err := LocalAddr(t.Local)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %d: %w", i, err)
+		}
+		t.Local = local
+		if t.Name == "" {
+			t.Name = local
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("duplicate tunnel name %q", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return &cfg, nil
+}
+
+// LocalAddr normalizes a port or host:port the way the CLI does.
+func LocalAddr(s string) (string, error) {
+	if strings.Contains(s, ":") {
+		return s, nil
+	}
+	if _, err := strconv.Atoi(s); err == nil {
+		return "localhost:" + s, nil
+	}
+	return "", fmt.Errorf("invalid local address %q (expected port or host:port)", s)
+}
+
+// Client builds the tunnel client for t.
+func (t TunnelConfig) Client(serverHost string) *client.Client {
+	c := client.New(net.JoinHostPort(serverHost, "4440"), net.JoinHostPort(serverHost, "4441"), t.Local, t.ClientID, "")
+	c.Name = t.Name
+	c.Reconnect = true
+	c.Auth = t.Auth
+	c.Token = t.Token
+	if c.Token == "" {
+		c.Token = os.Getenv("GOT_TOKEN")
+	}
+	c.AllowCIDRs, c.DenyCIDRs = t.AllowCIDRs, t.DenyCIDRs
+	c.Login = t.Login || len(t.LoginDomains) > 0
+	c.LoginEmailDomains = t.LoginDomains
+	c.Health = t.Health
+	if t.HostHeader != "" || len(t.RequestHeaders) > 0 || len(t.RemoveRequestHeaders) > 0 ||
+		len(t.ResponseHeaders) > 0 || len(t.RemoveResponseHeaders) > 0 {
+		c.HTTP = &client.HTTPOptions{
+			HostHeader:      t.HostHeader,
+			RequestHeaders:  client.HeaderRules{Add: toHeader(t.RequestHeaders), Remove: t.RemoveRequestHeaders},
+			ResponseHeaders: client.HeaderRules{Add: toHeader(t.ResponseHeaders), Remove: t.RemoveResponseHeaders},
+		}
+	}
+	return c
+}
+
+func toHeader(m map[string]string) http.Header {
+	if len(m) == 0 {
+		return nil
+	}
+	h := make(http.Header, len(m))
+	for k, v := range m {
+		h.Set(k, v)
+	}
+	return h
+}
+
+// Run starts every configured tunnel, serves the daemon control socket and
+// blocks until ctx is done, `got down` is received or all tunnels are stopped.
+func Run(ctx context.Context, cfg *Config) error {
+	ln, err := localapi.ListenDaemon()
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reg := localapi.NewRegistry()
+	reg.OnShutdown(cancel)
+	go func() { _ = localapi.Serve(ln, reg) }()
+
+	var wg sync.WaitGroup
+	for _, t := range cfg.Tunnels {
+		c := t.Client(cfg.Server)
+		tctx, stop := context.WithCancel(ctx)
+		if err := reg.Add(c, stop); err != nil {
+			stop()
+			cancel()
+			wg.Wait()
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer reg.Remove(c.Status().Name)
+			_ = c.Run(tctx)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,233 @@
+package fileserver
+
+import (
+	"compress/gzip"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Options controls how a directory is served
+type Options struct {
+	Listing bool // render directory listings when a directory has no index.html
+	SPA     bool // serve /index.html for unknown paths without a file extension
+	Gzip    bool // gzip compressible responses when the visitor accepts it
+
+	// Dotfiles serves files and directories whose name starts with a dot,
+	// such as .env or .git. They are hidden by default so sharing a project
+	// folder does not publish its secrets.
+	Dotfiles bool
+}
+
+// New returns a handler serving files from root. Range requests and
+// conditional GETs are handled by http.ServeContent.
+func New(root string, opts Options) http.Handler {
+	var dir http.FileSystem = http.Dir(root)
+	if !opts.Dotfiles {
+		dir = noDotfiles{dir}
+	}
+	var h http.Handler = &handler{
+		root:    dir,
+		listing: http.FileServer(dir),
+		opts:    opts,
+	}
+	if opts.Gzip {
+		h = gzipHandler(h)
+	}
+	return h
+}
+
+type handler struct {
+	root    http.FileSystem
+	listing http.Handler
+	opts    Options
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	upath := path.Clean("/" + r.URL.Path)
+	f, err := h.root.Open(upath)
+	if err != nil {
+		if h.opts.SPA && path.Ext(upath) == "" {
+			h.serveFile(w, r, "/index.html")
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !fi.IsDir() {
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return
+	}
+
+	// Directory: prefer its index.html, then a listing, then the SPA entrypoint
+	if h.exists(path.Join(upath, "index.html")) {
+		h.serveFile(w, r, path.Join(upath, "index.html"))
+		return
+	}
+	if h.opts.Listing {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		h.listing.ServeHTTP(w, r)
+		return
+	}
+	if h.opts.SPA {
+		h.serveFile(w, r, "/index.html")
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// noDotfiles hides every path with a dot-prefixed segment, both from Open
+// and from directory listings.
+type noDotfiles struct{ http.FileSystem }
+
+func (d noDotfiles) Open(name string) (http.File, error) {
+	if hasDotSegment(name) {
+		return nil, fs.ErrNotExist
+	}
+	f, err := d.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return noDotfilesFile{f}, nil
+}
+
+func hasDotSegment(name string) bool {
+	for _, seg := range strings.Split(name, "/") {
+		if strings.HasPrefix(seg, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+type noDotfilesFile struct{ http.File }
+
+func (f noDotfilesFile) Readdir(n int) ([]fs.FileInfo, error) {
+	fis, err := f.File.Readdir(n)
+	kept := fis[:0]
+	for _, fi := range fis {
+		if !strings.HasPrefix(fi.Name(), ".") {
+			kept = append(kept, fi)
+		}
+	}
+	return kept, err
+}
+
+func (h *handler) exists(name string) bool {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := h.root.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// gzipHandler compresses text-like responses. Range requests are passed
+// through untouched since byte offsets refer to the uncompressed file.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, _, _ = strings.Cut(enc, ";")
+		if strings.TrimSpace(enc) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mt, "text/") {
+		return true
+	}
+	switch mt {
+	case "application/javascript", "application/json", "application/xml",
+		"application/wasm", "image/svg+xml", "application/manifest+json":
+		return true
+	}
+	return false
+}
+
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+func (g *gzipWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
@@ -0,0 +1,70 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"index.html":  "index",
+		"app.js":      "js",
+		".env":        "SECRET=1",
+		".git/config": "[core]",
+		"sub/.hidden": "hidden",
+		"sub/ok.txt":  "ok",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func get(h http.Handler, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestDotfilesHidden(t *testing.T) {
+	h := New(testDir(t), Options{Listing: true, SPA: true})
+	for _, p := range []string{"/.env", "/.git/config", "/sub/.hidden", "/sub/../.env"} {
+		if code, body := get(h, p); strings.Contains(body, "SECRET") || strings.Contains(body, "[core]") || body == "hidden" {
+			t.Errorf("GET %s = %d %q, want dotfile hidden", p, code, body)
+		}
+	}
+	if code, body := get(h, "/sub/"); code != http.StatusOK || strings.Contains(body, ".hidden") || !strings.Contains(body, "ok.txt") {
+		t.Errorf("listing /sub/ = %d %q, want ok.txt without .hidden", code, body)
+	}
+	if code, body := get(h, "/app.js"); code != http.StatusOK || body != "js" {
+		t.Errorf("GET /app.js = %d %q", code, body)
+	}
+}
+
+func TestDotfilesOptIn(t *testing.T) {
+	h := New(testDir(t), Options{Dotfiles: true})
+	if code, body := get(h, "/.env"); code != http.StatusOK || body != "SECRET=1" {
+		t.Errorf("GET /.env = %d %q, want it served with Dotfiles", code, body)
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	h := New(testDir(t), Options{SPA: true})
+	if code, body := get(h, "/settings/profile"); code != http.StatusOK || body != "index" {
+		t.Errorf("GET /settings/profile = %d %q, want index.html", code, body)
+	}
+	if code, _ := get(h, "/missing.png"); code != http.StatusNotFound {
+		t.Errorf("GET /missing.png = %d, want 404", code)
+	}
+}
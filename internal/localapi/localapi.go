@@ -0,0 +1,367 @@
+// Package localapi exposes running tunnels over a per-process Unix socket so
+// that `got status`, `got ls`, `got stop` and `got pause` can reach them from
+// another terminal. Each client process listens on <SocketDir>/got-<pid>.sock;
+// the commands fan out over every socket in the directory.
+package localapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+)
+
+// ErrNotFound is returned by Stop, Pause and Resume when no running tunnel
+// has the given name
+var ErrNotFound = errors.New("tunnel not found")
+
+// SocketDir returns the directory holding client control sockets:
+// $XDG_RUNTIME_DIR/got when set, otherwise ~/.got/run.
+func SocketDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "got"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".got", "run"), nil
+}
+
+// Registry tracks the tunnels owned by this process
+type Registry struct {
+	mu       sync.Mutex
+	tunnels  map[string]*entry
+	shutdown func()
+}
+
+type entry struct {
+	client *client.Client
+	stop   func()
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{tunnels: make(map[string]*entry)}
+}
+
+// Add registers c under its status name; stop is called by `got stop`.
+// Names are unique within the registry, so commands reach the tunnel they
+// name: a second tunnel with the same name is refused.
+func (r *Registry) Add(c *client.Client, stop func()) error {
+	name := c.Status().Name
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tunnels[name]; ok {
+		return fmt.Errorf("duplicate tunnel name %q", name)
+	}
+	r.tunnels[name] = &entry{client: c, stop: stop}
+	return nil
+}
+
+// Remove forgets the tunnel with the given name.
+func (r *Registry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tunnels, name)
+}
+
+// Statuses returns a snapshot of every registered tunnel, sorted by name.
+func (r *Registry) Statuses() []client.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]client.Status, 0, len(r.tunnels))
+	for _, e := range r.tunnels {
+		out = append(out, e.client.Status())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Stop stops the named tunnel.
+func (r *Registry) Stop(name string) error {
+	r.mu.Lock()
+	e := r.tunnels[name]
+	r.mu.Unlock()
+	if e == nil {
+		return ErrNotFound
+	}
+	e.stop()
+	return nil
+}
+
+// Pause pauses the named tunnel, see client.Client.Pause.
+func (r *Registry) Pause(name, message string) error {
+	r.mu.Lock()
+	e := r.tunnels[name]
+	r.mu.Unlock()
+	if e == nil {
+		return ErrNotFound
+	}
+	return e.client.Pause(message)
+}
+
+// Resume resumes the named tunnel.
+func (r *Registry) Resume(name string) error {
+	r.mu.Lock()
+	e := r.tunnels[name]
+	r.mu.Unlock()
+	if e == nil {
+		return ErrNotFound
+	}
+	return e.client.Resume()
+}
+
+// OnShutdown lets `got down` stop the whole process through the API.
+func (r *Registry) OnShutdown(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shutdown = fn
+}
+
+// Listen creates this process's control socket.
+func Listen() (net.Listener, error) {
+	return listen(fmt.Sprintf("got-%d.sock", os.Getpid()))
+}
+
+// ListenDaemon creates the daemon's well-known control socket. It fails if
+// another daemon is already running.
+func ListenDaemon() (net.Listener, error) {
+	if DaemonRunning() {
+		return nil, errors.New("daemon already running")
+	}
+	return listen(daemonSocket)
+}
+
+const daemonSocket = "got-daemon.sock"
+
+func listen(name string) (net.Listener, error) {
+	dir, err := SocketDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, name)
+	_ = os.Remove(path) // stale socket from a previous process
+	return net.Listen("unix", path)
+}
+
+func daemonSocketPath() (string, error) {
+	dir, err := SocketDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, daemonSocket), nil
+}
+
+// DaemonRunning reports whether a daemon is answering on its socket.
+func DaemonRunning() bool {
+	path, err := daemonSocketPath()
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// ShutdownDaemon asks the running daemon to close its tunnels and exit.
+func ShutdownDaemon() error {
+	path, err := daemonSocketPath()
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient(path).Post("http://got/v1/shutdown", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		return fmt.Errorf("daemon not running: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("shutdown: %s", resp.Status)
+	}
+	return nil
+}
+
+// Serve answers local API requests on ln until it is closed.
+func Serve(ln net.Listener, reg *Registry) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/tunnels", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, reg.Statuses())
+	})
+	mux.HandleFunc("POST /v1/tunnels/{name}/stop", func(w http.ResponseWriter, r *http.Request) {
+		if err := reg.Stop(r.PathValue("name")); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /v1/tunnels/{name}/pause", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		tunnelResult(w, reg.Pause(r.PathValue("name"), req.Message))
+	})
+	mux.HandleFunc("POST /v1/tunnels/{name}/resume", func(w http.ResponseWriter, r *http.Request) {
+		tunnelResult(w, reg.Resume(r.PathValue("name")))
+	})
+	mux.HandleFunc("POST /v1/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		reg.mu.Lock()
+		fn := reg.shutdown
+		reg.mu.Unlock()
+		if fn == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not a daemon"})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fn()
+	})
+	err := http.Serve(ln, mux)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// tunnelResult answers a pause or resume request.
+func tunnelResult(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// sockets lists the control sockets of running clients, removing any left
+// behind by processes that are gone.
+func sockets() ([]string, error) {
+	dir, err := SocketDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "got-*.sock"))
+	if err != nil {
+		return nil, err
+	}
+	var live []string
+	for _, p := range paths {
+		conn, err := net.DialTimeout("unix", p, time.Second)
+		if err != nil {
+			_ = os.Remove(p)
+			continue
+		}
+		conn.Close()
+		live = append(live, p)
+	}
+	return live, nil
+}
+
+func httpClient(socket string) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// List returns the tunnels of every running client on this machine.
+func List() ([]client.Status, error) {
+	socks, err := sockets()
+	if err != nil {
+		return nil, err
+	}
+	var all []client.Status
+	for _, sock := range socks {
+		resp, err := httpClient(sock).Get("http://got/v1/tunnels")
+		if err != nil {
+			continue
+		}
+		var sts []client.Status
+		err = json.NewDecoder(resp.Body).Decode(&sts)
+		resp.Body.Close()
+		if err != nil {
+			continue
+		}
+		all = append(all, sts...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all, nil
+}
+
+// Stop asks whichever running client owns the named tunnel to stop it.
+func Stop(name string) error { return post(name, "stop", struct{}{}) }
+
+// Pause asks whichever running client owns the named tunnel to pause it,
+// showing message to visitors.
+func Pause(name, message string) error {
+	return post(name, "pause", map[string]string{"message": message})
+}
+
+// Resume asks whichever running client owns the named tunnel to resume it.
+func Resume(name string) error { return post(name, "resume", struct{}{}) }
+
+// post sends a tunnel action to each running client until the one owning
+// the named tunnel accepts it.
+func post(name, action string, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	socks, err := sockets()
+	if err != nil {
+		return err
+	}
+	for _, sock := range socks {
+		resp, err := httpClient(sock).Post("http://got/v1/tunnels/"+url.PathEscape(name)+"/"+action, "application/json", bytes.NewReader(b))
+		if err != nil {
+			continue
+		}
+		var res struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNoContent:
+			return nil
+		case http.StatusNotFound:
+			continue
+		}
+		return errors.New(res.Error)
+	}
+	return ErrNotFound
+}
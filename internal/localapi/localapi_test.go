@@ -0,0 +1,25 @@
+package localapi
+
+import (
+	"testing"
+
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+)
+
+func TestRegistryRefusesDuplicateName(t *testing.T) {
+	r := NewRegistry()
+	first := client.New("", "", "localhost:3000", "", "")
+	first.Name = "web"
+	second := client.New("", "", "localhost:4000", "", "")
+	second.Name = "web"
+
+	if err := r.Add(first, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(second, func() {}); err == nil {
+		t.Fatal("second tunnel named web was registered")
+	}
+	if got := r.Statuses(); len(got) != 1 || got[0].LocalAddr != "localhost:3000" {
+		t.Fatalf("statuses = %+v, want only the first tunnel", got)
+	}
+}
@@ -0,0 +1,66 @@
+// Package logging builds the log/slog loggers used by the server and the
+// client, and names the fields they share so logs from every component can
+// be filtered the same way. RotatingFile backs log files that must not grow
+// without bound.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Field keys attached to log records
+const (
+	TunnelID = "tunnel_id"
+	ClientID = "client_id"
+	ConnID   = "conn_id"
+	RemoteIP = "remote_ip"
+)
+
+// Output formats accepted by New
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing records at level and above to w as text or
+// JSON. level is "debug", "info", "warn" or "error".
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q (want text or json)", format)
+}
+
+// ParseLevel parses a level name such as "debug" or "WARN".
+func ParseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+	}
+	return lvl, nil
+}
+
+// Or returns l, or slog.Default() when l is nil, so components can leave
+// their Logger field unset.
+func Or(l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// Err is the attribute for an error.
+func Err(err error) slog.Attr {
+	return slog.Any("err", err)
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{
+		"debug":  slog.LevelDebug,
+		"info":   slog.LevelInfo,
+		"WARN":   slog.LevelWarn,
+		"Error":  slog.LevelError,
+		"info+2": slog.LevelInfo + 2,
+	} {
+		if got, err := ParseLevel(s); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "verbose", "warning"} {
+		if _, err := ParseLevel(s); err == nil {
+			t.Errorf("ParseLevel(%q) succeeded", s)
+		}
+	}
+}
+
+func TestNewFormats(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, "warn", FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("dropped")
+	l.Warn("tunnel closed", TunnelID, "t1")
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("JSON output %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "tunnel closed" || rec["level"] != "WARN" || rec[TunnelID] != "t1" {
+		t.Fatalf("record = %v", rec)
+	}
+
+	buf.Reset()
+	if l, err = New(&buf, "info", ""); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("tunnel opened", ClientID, "c1")
+	if got := buf.String(); !strings.Contains(got, `msg="tunnel opened"`) || !strings.Contains(got, "client_id=c1") {
+		t.Fatalf("text output = %q", got)
+	}
+	if _, err := New(&buf, "info", "xml"); err == nil {
+		t.Fatal("New accepted an unknown format")
+	}
+}
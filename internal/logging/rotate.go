@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is an io.Writer appending to a file that is rotated once it
+// would grow past its size limit: path is renamed to path.1, path.1 to
+// path.2 and so on, and the oldest backup beyond the limit is removed. It is
+// safe for concurrent use.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotating opens path for appending, creating it and its directory if
+// needed. maxSize 0 never rotates; maxBackups is how many rotated files to
+// keep.
+func OpenRotating(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := r.openLocked(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path returns the file currently written to.
+func (r *RotatingFile) Path() string { return r.path }
+
+// Backups returns the rotated files that exist, newest first.
+func (r *RotatingFile) Backups() []string {
+	var files []string
+	for i := 1; i <= r.maxBackups; i++ {
+		p := r.backup(i)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		// A failed rotation keeps appending to path, and tries again next
+		// time, rather than losing every later line.
+		if err := r.rotateLocked(); err != nil && r.f == nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+func (r *RotatingFile) backup(i int) string { return fmt.Sprintf("%s.%d", r.path, i) }
+
+func (r *RotatingFile) openLocked() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// rotateLocked moves path to the first backup and opens a new path. Path
+// is reopened for appending even when the move fails.
+func (r *RotatingFile) rotateLocked() error {
+	err := r.f.Close()
+	r.f = nil
+	if err == nil {
+		err = r.shiftLocked()
+	}
+	if oerr := r.openLocked(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+func (r *RotatingFile) shiftLocked() error {
+	if r.maxBackups <= 0 {
+		return os.Remove(r.path)
+	}
+	_ = os.Remove(r.backup(r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(r.backup(i), r.backup(i+1))
+	}
+	return os.Rename(r.path, r.backup(1))
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	r, err := OpenRotating(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readFile(t, path); got != "fourth\n" {
+		t.Fatalf("current file = %q", got)
+	}
+	if got := r.Backups(); len(got) != 2 || got[0] != path+".1" || got[1] != path+".2" {
+		t.Fatalf("Backups = %v", got)
+	}
+	// The oldest line fell off the end of the two backups.
+	if got := readFile(t, path+".1") + readFile(t, path+".2"); got != "third\nsecond\n" {
+		t.Fatalf("backups hold %q", got)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// A non-empty directory where the backup goes cannot be removed or
+	// replaced, so every rotation fails.
+	if err := os.MkdirAll(filepath.Join(path+".1", "keep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenRotating(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) = %v", line, err)
+		}
+	}
+	if got := readFile(t, path); got != "first\nsecond\nthird\n" {
+		t.Fatalf("file = %q, want every line appended", got)
+	}
+}
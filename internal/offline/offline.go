@@ -0,0 +1,105 @@
+// Package offline renders the page visitors see when a tunnel is up but the
+// app behind it is not answering. Both the server edge and the client use
+// it, so visitors get the same 502 wherever the failure is noticed. It also
+// renders the 503 shown while a tunnel is paused.
+package offline
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// RetryAfter is the Retry-After hint, in seconds, sent with the page
+const RetryAfter = 5
+
+var page = template.Must(template.New("offline").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="{{.RetryAfter}}">
+<title>App {{if .Paused}}paused{{else}}offline{{end}} · got</title>
+<style>
+  body { margin: 0; min-height: 100vh; display: grid; place-items: center; background: #0f172a; color: #e2e8f0; font: 16px/1.5 system-ui, -apple-system, sans-serif; }
+  main { max-width: 32rem; padding: 2rem; text-align: center; }
+  h1 { font-size: 1.5rem; margin: 0 0 .5rem; }
+  p { color: #94a3b8; margin: .5rem 0; }
+  code { color: #38bdf8; }
+  .brand { margin-top: 2rem; font-size: .875rem; color: #475569; }
+</style>
+</head>
+<body>
+<main>
+{{- if .Paused}}
+  <h1>This app is paused</h1>
+  <p>The owner of <code>{{.Host}}</code> has paused it for a moment, for example for maintenance.</p>
+{{- with .Message}}
+  <p>{{.}}</p>
+{{- end}}
+{{- else}}
+  <h1>This app is offline</h1>
+  <p>The tunnel to <code>{{.Host}}</code> is connected, but the app behind it is not responding.</p>
+  <p>If this is your app, check that it is running and listening on the port you shared.</p>
+{{- end}}
+  <p>This page will retry in {{.RetryAfter}} seconds.</p>
+  <p class="brand">Served by got</p>
+</main>
+</body>
+</html>
+`))
+
+// Page renders the offline page for the tunnel at host.
+func Page(host string) []byte { return render(host, false, "") }
+
+// PausedPage renders the page for a paused tunnel at host, with the owner's
+// message if any.
+func PausedPage(host, message string) []byte { return render(host, true, message) }
+
+func render(host string, paused bool, message string) []byte {
+	var buf bytes.Buffer
+	_ = page.Execute(&buf, struct {
+		Host       string
+		RetryAfter int
+		Paused     bool
+		Message    string
+	}{host, RetryAfter, paused, message})
+	return buf.Bytes()
+}
+
+// ServeHTTP writes the offline page as a 502 response.
+func ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := Page(r.Host)
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Retry-After", strconv.Itoa(RetryAfter))
+	w.WriteHeader(http.StatusBadGateway)
+	_, _ = w.Write(body)
+}
+
+// WriteResponse writes the offline page as a raw HTTP/1.1 502 response to
+// w, for callers holding a connection rather than a ResponseWriter.
+func WriteResponse(w io.Writer, host string) error {
+	return WriteRaw(w, http.StatusBadGateway, "text/html; charset=utf-8", Page(host))
+}
+
+// WritePaused writes the paused page as a raw HTTP/1.1 503 response to w.
+func WritePaused(w io.Writer, host, message string) error {
+	return WriteRaw(w, http.StatusServiceUnavailable, "text/html; charset=utf-8", PausedPage(host, message))
+}
+
+// WriteRaw writes body as a raw HTTP/1.1 response with status and a
+// Retry-After hint, announcing that the connection will close.
+func WriteRaw(w io.Writer, status int, contentType string, body []byte) error {
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n"+
+		"Content-Type: %s\r\n"+
+		"Cache-Control: no-store\r\n"+
+		"Retry-After: %d\r\n"+
+		"Content-Length: %d\r\n"+
+		"Connection: close\r\n\r\n%s", status, http.StatusText(status), contentType, RetryAfter, len(body), body)
+	return err
+}
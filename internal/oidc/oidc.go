@@ -0,0 +1,460 @@
+// Package oidc implements an OpenID Connect login gate for tunnels. Visitors
+// are sent through the authorization code flow of a configured identity
+// provider; once their email is verified against the allowed domains a
+// signed session cookie, scoped to the tunnel host, lets further requests
+// through with identity headers attached.
+//
+// The ID token is obtained directly from the provider's token endpoint over
+// TLS, so per OpenID Connect Core 3.1.3.7 its issuer is established by the
+// TLS connection and its signature is not verified separately.
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CallbackPath is served on every gated tunnel host; register
+// https://*.<domain>/.got/oidc/callback (or each host) with the provider.
+const CallbackPath = "/.got/oidc/callback"
+
+const (
+	sessionCookie = "got_session"
+	stateCookie   = "got_oidc_state"
+)
+
+// Identity headers set on requests forwarded to the local app. Any values
+// sent by the visitor are removed first.
+const (
+	HeaderEmail   = "X-Forwarded-Email"
+	HeaderUser    = "X-Forwarded-User"
+	HeaderSubject = "X-Got-Subject"
+)
+
+// Config for an identity provider
+type Config struct {
+	Issuer       string   // e.g. https://accounts.google.com
+	ClientID     string   // OAuth client ID registered with the provider
+	ClientSecret string   // OAuth client secret
+	EmailDomains []string // allowed email domains; empty allows any verified email
+	Scopes       []string // defaults to openid, email, profile
+
+	// CookieSecret signs session cookies. A random key is used when empty,
+	// which logs everyone out when the server restarts.
+	CookieSecret []byte
+	SessionTTL   time.Duration // defaults to 12h
+	HTTPClient   *http.Client  // defaults to a client with a 10s timeout
+}
+
+// Provider gates requests behind a login with one identity provider
+type Provider struct {
+	cfg Config
+
+	mu        sync.Mutex
+	discovery *discovery
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// New validates cfg. Provider metadata is fetched lazily on first use.
+func New(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("oidc: issuer and client ID are required")
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if len(cfg.CookieSecret) == 0 {
+		cfg.CookieSecret = make([]byte, 32)
+		if _, err := rand.Read(cfg.CookieSecret); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = 12 * time.Hour
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	for i, d := range cfg.EmailDomains {
+		cfg.EmailDomains[i] = strings.ToLower(strings.TrimSpace(d))
+	}
+	return &Provider{cfg: cfg}, nil
+}
+
+func (p *Provider) endpoints(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery: %s", resp.Status)
+	}
+	var d discovery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	p.discovery = &d
+	return &d, nil
+}
+
+// Gate protects next for a single tunnel. emailDomains further narrows the
+// provider's allowed domains for this tunnel.
+func (p *Provider) Gate(emailDomains []string, next http.Handler) http.Handler {
+	g := &gate{p: p, next: next}
+	for _, d := range emailDomains {
+		g.domains = append(g.domains, strings.ToLower(strings.TrimSpace(d)))
+	}
+	return g
+}
+
+type gate struct {
+	p       *Provider
+	domains []string
+	next    http.Handler
+}
+
+type session struct {
+	Email   string `json:"e"`
+	Subject string `json:"s"`
+	Name    string `json:"n,omitempty"`
+	Host    string `json:"h"`
+	Expires int64  `json:"x"`
+}
+
+type loginState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	ReturnTo string `json:"r"`
+	Host     string `json:"h"`
+	Expires  int64  `json:"x"`
+}
+
+func (g *gate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == CallbackPath {
+		g.callback(w, r)
+		return
+	}
+
+	var sess session
+	if c, err := r.Cookie(sessionCookie); err == nil && g.p.verify(c.Value, &sess) &&
+		sess.Host == r.Host && time.Now().Unix() < sess.Expires && g.emailAllowed(sess.Email) {
+		for _, h := range []string{HeaderEmail, HeaderUser, HeaderSubject} {
+			r.Header.Del(h)
+		}
+		r.Header.Set(HeaderEmail, sess.Email)
+		r.Header.Set(HeaderUser, sess.Name)
+		r.Header.Set(HeaderSubject, sess.Subject)
+		stripCookies(r, sessionCookie, stateCookie)
+		g.next.ServeHTTP(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "401 Unauthorized: log in first", http.StatusUnauthorized)
+		return
+	}
+	g.login(w, r)
+}
+
+// login starts the authorization code flow, remembering where to return.
+func (g *gate) login(w http.ResponseWriter, r *http.Request) {
+	d, err := g.p.endpoints(r.Context())
+	if err != nil {
+		http.Error(w, "login provider unavailable", http.StatusBadGateway)
+		return
+	}
+	st := loginState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		ReturnTo: r.URL.RequestURI(),
+		Host:     r.Host,
+		Expires:  time.Now().Add(10 * time.Minute).Unix(),
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    g.p.sign(st),
+		Path:     CallbackPath,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   scheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {g.p.cfg.ClientID},
+		"redirect_uri":  {redirectURI(r)},
+		"scope":         {strings.Join(g.p.cfg.Scopes, " ")},
+		"state":         {st.State},
+		"nonce":         {st.Nonce},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, d.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+func (g *gate) callback(w http.ResponseWriter, r *http.Request) {
+	var st loginState
+	c, err := r.Cookie(stateCookie)
+	if err != nil || !g.p.verify(c.Value, &st) || st.Host != r.Host || time.Now().Unix() > st.Expires {
+		http.Error(w, "login expired, please retry", http.StatusBadRequest)
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, "login failed: "+e, http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("state") != st.State {
+		http.Error(w, "login state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := g.p.exchange(r.Context(), r.URL.Query().Get("code"), redirectURI(r))
+	if err != nil {
+		http.Error(w, "login failed", http.StatusBadGateway)
+		return
+	}
+	if claims.Nonce != st.Nonce {
+		http.Error(w, "login nonce mismatch", http.StatusBadRequest)
+		return
+	}
+	if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) || !g.emailAllowed(claims.Email) {
+		http.Error(w, "403 Forbidden: "+claims.Email+" is not allowed", http.StatusForbidden)
+		return
+	}
+
+	sess := session{
+		Email:   claims.Email,
+		Subject: claims.Subject,
+		Name:    claims.Name,
+		Host:    r.Host,
+		Expires: time.Now().Add(g.p.cfg.SessionTTL).Unix(),
+	}
+	secure := scheme(r) == "https"
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: CallbackPath, MaxAge: -1, HttpOnly: true, Secure: secure})
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    g.p.sign(sess),
+		Path:     "/",
+		MaxAge:   int(g.p.cfg.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	returnTo := st.ReturnTo
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+type idClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	Expires       int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified *bool           `json:"email_verified"`
+	Name          string          `json:"name"`
+}
+
+// exchange redeems an authorization code and returns the validated ID token claims.
+func (p *Provider) exchange(ctx context.Context, code, redirect string) (*idClaims, error) {
+	d, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("token endpoint: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(tok.IDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed id_token: %w", err)
+	}
+	var c idClaims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("malformed id_token: %w", err)
+	}
+	if strings.TrimSuffix(c.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("id_token issuer %q", c.Issuer)
+	}
+	if !audienceContains(c.Audience, p.cfg.ClientID) {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	if time.Now().Unix() >= c.Expires {
+		return nil, errors.New("id_token expired")
+	}
+	return &c, nil
+}
+
+func audienceContains(raw json.RawMessage, clientID string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == clientID
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, a := range many {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emailAllowed checks the address against the provider and tunnel domains.
+func (g *gate) emailAllowed(email string) bool {
+	_, domain, ok := strings.Cut(strings.ToLower(email), "@")
+	if !ok {
+		return false
+	}
+	return domainListed(g.p.cfg.EmailDomains, domain) && domainListed(g.domains, domain)
+}
+
+func domainListed(list []string, domain string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, d := range list {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// sign encodes v as base64url JSON followed by an HMAC-SHA256 signature.
+func (p *Provider) sign(v any) string {
+	b, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, p.cfg.CookieSecret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *Provider) verify(value string, v any) bool {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	want, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, p.cfg.CookieSecret)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, v) == nil
+}
+
+// stripCookies removes the gate's own cookies before the request reaches the app.
+func stripCookies(r *http.Request, names ...string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		keep := true
+		for _, n := range names {
+			if c.Name == n {
+				keep = false
+			}
+		}
+		if keep {
+			r.AddCookie(c)
+		}
+	}
+}
+
+func scheme(r *http.Request) string {
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		return p
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func redirectURI(r *http.Request) string {
+	return scheme(r) + "://" + r.Host + CallbackPath
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
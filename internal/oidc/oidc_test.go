@@ -0,0 +1,309 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIdP is an identity provider whose token endpoint answers every code
+// with an ID token carrying claims.
+type testIdP struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	claims idClaims
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	idp := &testIdP{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(discovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good-code" || r.PostFormValue("client_secret") != "s3cret" {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		idp.mu.Lock()
+		payload, _ := json.Marshal(idp.claims)
+		idp.mu.Unlock()
+		token := "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": token})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// issue sets the claims of the next ID token, valid for the given nonce.
+func (idp *testIdP) issue(nonce, email string, edit func(*idClaims)) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	verified := true
+	idp.claims = idClaims{
+		Issuer:        idp.URL,
+		Subject:       "user-1",
+		Audience:      json.RawMessage(`"got"`),
+		Expires:       time.Now().Add(time.Hour).Unix(),
+		Nonce:         nonce,
+		Email:         email,
+		EmailVerified: &verified,
+		Name:          "Alice",
+	}
+	if edit != nil {
+		edit(&idp.claims)
+	}
+}
+
+func testProvider(t *testing.T, idp *testIdP, domains ...string) *Provider {
+	t.Helper()
+	p, err := New(Config{
+		Issuer:       idp.URL,
+		ClientID:     "got",
+		ClientSecret: "s3cret",
+		EmailDomains: domains,
+		HTTPClient:   idp.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func serve(h http.Handler, r *http.Request) *http.Response {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func cookie(t *testing.T, resp *http.Response, name string) *http.Cookie {
+	t.Helper()
+	for _, c := range resp.Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	t.Fatalf("response sets no %s cookie", name)
+	return nil
+}
+
+// startLogin visits target unauthenticated and returns the state cookie and
+// the parameters sent to the provider.
+func startLogin(t *testing.T, g http.Handler, target string) (*http.Cookie, url.Values) {
+	t.Helper()
+	resp := serve(g, httptest.NewRequest(http.MethodGet, target, nil))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("unauthenticated visit = %d, want a redirect to the provider", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookie(t, resp, stateCookie), loc.Query()
+}
+
+// finishLogin calls back on host with the given state and the state cookie.
+func finishLogin(g http.Handler, host string, state *http.Cookie, stateParam string) *http.Response {
+	r := httptest.NewRequest(http.MethodGet, "http://"+host+CallbackPath+"?code=good-code&state="+url.QueryEscape(stateParam), nil)
+	r.AddCookie(state)
+	return serve(g, r)
+}
+
+func TestLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	var forwarded *http.Request
+	g := testProvider(t, idp).Gate(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	}))
+
+	state, q := startLogin(t, g, "http://app.example.com/reports?page=2")
+	if q.Get("client_id") != "got" || q.Get("redirect_uri") != "http://app.example.com"+CallbackPath {
+		t.Fatalf("authorization request = %v", q)
+	}
+	idp.issue(q.Get("nonce"), "alice@example.com", nil)
+	resp := finishLogin(g, "app.example.com", state, q.Get("state"))
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/reports?page=2" {
+		t.Fatalf("callback = %d to %q, want a redirect back to the page", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	sess := cookie(t, resp, sessionCookie)
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.example.com/reports", nil)
+	r.AddCookie(sess)
+	r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	r.Header.Set(HeaderEmail, "admin@example.com")
+	if resp := serve(g, r); resp.StatusCode != http.StatusOK || forwarded == nil {
+		t.Fatalf("logged-in visit = %d", resp.StatusCode)
+	}
+	if got := forwarded.Header.Values(HeaderEmail); len(got) != 1 || got[0] != "alice@example.com" {
+		t.Fatalf("%s = %q, want the session's email only", HeaderEmail, got)
+	}
+	if forwarded.Header.Get(HeaderUser) != "Alice" || forwarded.Header.Get(HeaderSubject) != "user-1" {
+		t.Fatalf("identity headers = %v", forwarded.Header)
+	}
+	if _, err := forwarded.Cookie(sessionCookie); err == nil {
+		t.Fatal("session cookie reached the app")
+	}
+	if _, err := forwarded.Cookie("theme"); err != nil {
+		t.Fatal("the app's own cookie was stripped")
+	}
+}
+
+func TestCookiesBoundToHost(t *testing.T) {
+	idp := newTestIdP(t)
+	g := testProvider(t, idp).Gate(nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	state, q := startLogin(t, g, "http://a.example.com/")
+	idp.issue(q.Get("nonce"), "alice@example.com", nil)
+	// A state cookie minted for one tunnel does not complete a login on
+	// another.
+	if resp := finishLogin(g, "b.example.com", state, q.Get("state")); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("callback on another host = %d, want 400", resp.StatusCode)
+	}
+	sess := cookie(t, finishLogin(g, "a.example.com", state, q.Get("state")), sessionCookie)
+
+	r := httptest.NewRequest(http.MethodGet, "http://b.example.com/", nil)
+	r.AddCookie(sess)
+	if resp := serve(g, r); resp.StatusCode != http.StatusFound {
+		t.Fatalf("session replayed on another host = %d, want a new login", resp.StatusCode)
+	}
+	r = httptest.NewRequest(http.MethodPost, "http://b.example.com/", nil)
+	r.AddCookie(sess)
+	if resp := serve(g, r); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST with another host's session = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestCallbackRejects(t *testing.T) {
+	for name, tc := range map[string]struct {
+		state  func(q url.Values) string
+		claims func(c *idClaims)
+		status int
+	}{
+		"state mismatch": {
+			state:  func(url.Values) string { return "forged" },
+			status: http.StatusBadRequest,
+		},
+		"nonce mismatch": {
+			claims: func(c *idClaims) { c.Nonce = "replayed" },
+			status: http.StatusBadRequest,
+		},
+		"expired id token": {
+			claims: func(c *idClaims) { c.Expires = time.Now().Add(-time.Minute).Unix() },
+			status: http.StatusBadGateway,
+		},
+		"wrong audience": {
+			claims: func(c *idClaims) { c.Audience = json.RawMessage(`["someone-else"]`) },
+			status: http.StatusBadGateway,
+		},
+		"wrong issuer": {
+			claims: func(c *idClaims) { c.Issuer = "https://evil.example" },
+			status: http.StatusBadGateway,
+		},
+		"unverified email": {
+			claims: func(c *idClaims) { c.EmailVerified = new(bool) },
+			status: http.StatusForbidden,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			idp := newTestIdP(t)
+			g := testProvider(t, idp).Gate(nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			state, q := startLogin(t, g, "http://app.example.com/")
+			idp.issue(q.Get("nonce"), "alice@example.com", tc.claims)
+			param := q.Get("state")
+			if tc.state != nil {
+				param = tc.state(q)
+			}
+			resp := finishLogin(g, "app.example.com", state, param)
+			if resp.StatusCode != tc.status {
+				t.Fatalf("callback = %d, want %d", resp.StatusCode, tc.status)
+			}
+			for _, c := range resp.Cookies() {
+				if c.Name == sessionCookie {
+					t.Fatal("rejected login set a session cookie")
+				}
+			}
+		})
+	}
+}
+
+func TestReturnToStaysOnTunnel(t *testing.T) {
+	idp := newTestIdP(t)
+	p := testProvider(t, idp)
+	g := p.Gate(nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for returnTo, want := range map[string]string{
+		"/inbox?x=1":           "/inbox?x=1",
+		"//evil.example/x":     "/",
+		"https://evil.example": "/",
+		"evil.example":         "/",
+	} {
+		st := loginState{
+			State:    "st",
+			Nonce:    "n",
+			ReturnTo: returnTo,
+			Host:     "app.example.com",
+			Expires:  time.Now().Add(time.Minute).Unix(),
+		}
+		idp.issue(st.Nonce, "alice@example.com", nil)
+		resp := finishLogin(g, st.Host, &http.Cookie{Name: stateCookie, Value: p.sign(st)}, st.State)
+		if got := resp.Header.Get("Location"); resp.StatusCode != http.StatusFound || got != want {
+			t.Errorf("ReturnTo %q: redirected %d to %q, want %q", returnTo, resp.StatusCode, got, want)
+		}
+	}
+}
+
+func TestEmailAllowed(t *testing.T) {
+	idp := newTestIdP(t)
+	p := testProvider(t, idp, "Example.com", "corp.example")
+	for name, tc := range map[string]struct {
+		tunnel []string
+		email  string
+		want   bool
+	}{
+		"provider domain":         {email: "alice@example.com", want: true},
+		"case-insensitive":        {email: "Alice@EXAMPLE.com", want: true},
+		"outside provider":        {email: "eve@evil.example", want: false},
+		"subdomain is not listed": {email: "alice@dev.example.com", want: false},
+		"no domain":               {email: "alice", want: false},
+		"narrowed by tunnel":      {tunnel: []string{"corp.example"}, email: "alice@example.com", want: false},
+		"allowed by both":         {tunnel: []string{"corp.example"}, email: "bob@corp.example", want: true},
+		// A tunnel cannot widen what the provider allows.
+		"tunnel cannot widen": {tunnel: []string{"evil.example"}, email: "eve@evil.example", want: false},
+	} {
+		g := p.Gate(tc.tunnel, nil).(*gate)
+		if got := g.emailAllowed(tc.email); got != tc.want {
+			t.Errorf("%s: emailAllowed(%q) = %v, want %v", name, tc.email, got, tc.want)
+		}
+	}
+}
+
+func TestAudienceContains(t *testing.T) {
+	for aud, want := range map[string]bool{
+		`"got"`:             true,
+		`"other"`:           false,
+		`["other", "got"]`:  true,
+		`["other"]`:         false,
+		`[]`:                false,
+		`null`:              false,
+		`{"aud": "got"}`:    false,
+		`"got-and-more"`:    false,
+		`["got-and-more"]`:  false,
+		`["GOT"]`:           false,
+		`["got", "a", "b"]`: true,
+	} {
+		if got := audienceContains(json.RawMessage(aud), "got"); got != want {
+			t.Errorf("audienceContains(%s) = %v, want %v", aud, got, want)
+		}
+	}
+}
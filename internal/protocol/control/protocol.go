@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 )
 
 // Message types exchanged over the persistent control connection and
@@ -13,11 +14,45 @@ import (
 // Server to open a tunnel
 // Client -> Server
 type OpenTunnel struct {
-	Type      string `json:"type"`       // "open_tunnel"
-	ClientID  string `json:"client_id"`  // optional identifier
-	LocalHint string `json:"local_hint"` // optional label for debugging
-	Domain    string `json:"domain"`     // optional domain to use for the tunnel
-	LocalURL  string `json:"local_url"`  // local URL for health checking (e.g., "http://localhost:3000")
+	Type      string `json:"type"`            // "open_tunnel"
+	ClientID  string `json:"client_id"`       // optional identifier
+	Token     string `json:"token,omitempty"` // optional API token; rate limits apply per token as well as per IP
+	LocalHint string `json:"local_hint"`      // optional label for debugging
+	Domain    string `json:"domain"`          // optional domain to use for the tunnel
+	LocalURL  string `json:"local_url"`       // local URL for health checking (e.g., "http://localhost:3000")
+
+	BasicAuth  *BasicAuth `json:"basic_auth,omitempty"`  // optional credentials enforced at the server edge
+	AllowCIDRs []string   `json:"allow_cidrs,omitempty"` // optional visitor allowlist (CIDRs or IPs)
+	DenyCIDRs  []string   `json:"deny_cidrs,omitempty"`  // optional visitor denylist, checked first
+	OIDC       *OIDCGate  `json:"oidc,omitempty"`        // optional login gate using the server's identity provider
+
+	Health *HealthPolicy `json:"health,omitempty"` // optional; the server's default policy applies otherwise
+}
+
+// HealthPolicy configures the server's health checks for a tunnel. Probes are
+// HTTP GETs sent through the tunnel to the local app. Zero fields take the
+// server's defaults.
+type HealthPolicy struct {
+	Disabled        bool   `json:"disabled,omitempty"`
+	Path            string `json:"path,omitempty"`             // default "/"
+	ExpectStatus    string `json:"expect_status,omitempty"`    // e.g. "200-299,404"; default: anything below 500
+	IntervalSeconds int    `json:"interval_seconds,omitempty"` // default 120
+	TimeoutSeconds  int    `json:"timeout_seconds,omitempty"`  // default 10
+	Threshold       int    `json:"threshold,omitempty"`        // consecutive failures before Action applies; default 3
+	Action          string `json:"action,omitempty"`           // "warn" (default) or "close"
+}
+
+// OIDCGate asks the server to require an OpenID Connect login before
+// visitors reach the tunnel.
+type OIDCGate struct {
+	EmailDomains []string `json:"email_domains,omitempty"` // narrows the server's allowed email domains
+}
+
+// BasicAuth credentials visitors must present (HTTP Basic) before the
+// server forwards their requests to the client.
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 // Server to client with a TunnelID and the public host:port on the server accessible publicly
@@ -30,8 +65,77 @@ type TunnelOpened struct {
 
 // Server to client with an error message
 type TunnelError struct {
-	Type  string `json:"type"` // "tunnel_error"
-	Error string `json:"error"`
+	Type       string `json:"type"` // "tunnel_error"
+	Error      string `json:"error"`
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds to wait before retrying, when rate limited
+	Permanent  bool   `json:"permanent,omitempty"`   // retrying the same request cannot succeed, e.g. a permanent ban or invalid options
+}
+
+// Server to client when the client has used up a transfer quota. With
+// action "close" the server closes the tunnel after sending it; with
+// "pause" visitors are turned away until ResetAt.
+type QuotaExceeded struct {
+	Type     string    `json:"type"` // "quota_exceeded"
+	TunnelID string    `json:"tunnel_id"`
+	Period   string    `json:"period"` // "daily" or "monthly"
+	Limit    int64     `json:"limit"`  // bytes
+	Used     int64     `json:"used"`   // bytes
+	Action   string    `json:"action"` // "close" or "pause"
+	ResetAt  time.Time `json:"reset_at"`
+	Message  string    `json:"message"`
+}
+
+// Server to client with the result of each health probe
+type HealthReport struct {
+	Type      string `json:"type"` // "health_report"
+	TunnelID  string `json:"tunnel_id"`
+	OK        bool   `json:"ok"`
+	Status    int    `json:"status,omitempty"` // HTTP status, when the app answered
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Failures  int    `json:"failures"` // consecutive failures including this one
+	Threshold int    `json:"threshold"`
+	Action    string `json:"action"` // "warn" or "close"
+}
+
+// Client to server when the local app stops or starts accepting
+// connections. The server then serves an offline page instead of
+// forwarding visitors.
+type UpstreamStatus struct {
+	Type     string `json:"type"` // "upstream_status"
+	TunnelID string `json:"tunnel_id"`
+	Up       bool   `json:"up"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Client to server to stop forwarding visitors without closing the tunnel.
+// Until resume_tunnel, the server answers them with its maintenance
+// response and sends no ConnRequest; the tunnel keeps its host and port.
+type PauseTunnel struct {
+	Type     string `json:"type"` // "pause_tunnel"
+	TunnelID string `json:"tunnel_id"`
+	Message  string `json:"message,omitempty"` // shown to visitors on the default page
+}
+
+// Client to server to forward visitors again after pause_tunnel
+type ResumeTunnel struct {
+	Type     string `json:"type"` // "resume_tunnel"
+	TunnelID string `json:"tunnel_id"`
+}
+
+// Server to client every few seconds while the tunnel's visitor traffic
+// changes. Counters are totals since the tunnel opened, as seen on the
+// public side of the server.
+type TunnelStats struct {
+	Type          string `json:"type"` // "tunnel_stats"
+	TunnelID      string `json:"tunnel_id"`
+	ActiveConns   int64  `json:"active_conns"` // visitor connections open now
+	TotalConns    int64  `json:"total_conns"`
+	Requests      int64  `json:"requests"` // HTTP requests; only counted for tunnels served through the HTTP edge
+	BytesIn       int64  `json:"bytes_in"` // from visitors
+	BytesOut      int64  `json:"bytes_out"`
+	Errors        int64  `json:"errors"` // 5xx responses, or visitors that could not be bridged
+	LastVisitorIP string `json:"last_visitor_ip,omitempty"`
 }
 
 // Client asking it to open a data connection to the server for incoming connections
@@ -39,6 +143,8 @@ type ConnRequest struct {
 	Type     string `json:"type"` // "conn_request"
 	TunnelID string `json:"tunnel_id"`
 	ConnID   string `json:"conn_id"`
+	// W3C traceparent of the server's span for this visitor, when tracing
+	Traceparent string `json:"traceparent,omitempty"`
 }
 
 // Sent by client on a short-lived TCP connection to initialize a data pipe.
@@ -46,12 +152,16 @@ type DataInit struct {
 	Type     string `json:"type"` // "data_init"
 	TunnelID string `json:"tunnel_id"`
 	ConnID   string `json:"conn_id"`
+	// W3C traceparent of the client's span for this connection, when tracing
+	Traceparent string `json:"traceparent,omitempty"`
 }
 
-// Heartbeat message from client to server
+// Heartbeat message from client to server. The server echoes it back, so
+// the client can measure its round trip.
 type Heartbeat struct {
 	Type     string `json:"type"` // "heartbeat"
 	TunnelID string `json:"tunnel_id"`
+	Seq      int64  `json:"seq,omitempty"`
 }
 
 // JSON line helpers
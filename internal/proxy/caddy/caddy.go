@@ -5,13 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/HeyRistaa/got/internal/logging"
 )
 
 // Client handles Caddy Admin API interactions
 type Client struct {
 	AdminURL string
+
+	// Logger receives route changes; nil logs to slog.Default().
+	Logger *slog.Logger
 }
 
 // New creates a new Caddy client
@@ -23,7 +29,8 @@ func New(adminURL string) *Client {
 
 // AddRoute adds a new route to Caddy
 func (c *Client) AddRoute(host string, port int) error {
-	fmt.Printf("Adding Caddy route: %s -> 127.0.0.1:%d\n", host, port)
+	log := logging.Or(c.Logger).With("host", host)
+	log.Debug("adding caddy route", "port", port)
 
 	body := map[string]any{
 		"match": []map[string]any{{"host": []string{host}}},
@@ -40,22 +47,22 @@ func (c *Client) AddRoute(host string, port int) error {
 
 	req, err := http.NewRequest("POST", c.AdminURL+"/config/apps/http/servers/srv0/routes", &buf)
 	if err != nil {
-		fmt.Printf("Failed to create Caddy request for %s: %v\n", host, err)
+		log.Error("create caddy request", logging.Err(err))
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		fmt.Printf("Failed to send Caddy request for %s: %v\n", host, err)
+		log.Error("send caddy request", logging.Err(err))
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Caddy route add failed for %s: %s - %s\n", host, resp.Status, strings.TrimSpace(string(b)))
+		log.Error("caddy route add failed", "status", resp.Status, "body", strings.TrimSpace(string(b)))
 		return fmt.Errorf("caddy add route: %s: %s", resp.Status, strings.TrimSpace(string(b)))
 	}
-	fmt.Printf("Successfully added Caddy route for %s\n", host)
+	log.Info("caddy route added", "port", port)
 	return nil
 }
 
@@ -0,0 +1,255 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/logging"
+)
+
+// Exporter limits
+const (
+	exportInterval = 5 * time.Second
+	exportBatch    = 512
+	maxQueued      = 8192 // spans beyond this are dropped until the next export
+)
+
+// Exporter batches finished spans and POSTs them to an OTLP/HTTP collector
+// as JSON.
+type Exporter struct {
+	url    string
+	client *http.Client
+
+	// Logger receives export failures; nil logs to slog.Default().
+	Logger *slog.Logger
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewExporter starts exporting spans to the collector at endpoint, e.g.
+// "http://localhost:4318"; "/v1/traces" is appended unless endpoint already
+// names that path.
+func NewExporter(endpoint string) *Exporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	e := &Exporter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *Exporter) add(s *Span) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if len(e.queue) >= maxQueued {
+		e.dropped++
+	} else {
+		e.queue = append(e.queue, s)
+	}
+	full := len(e.queue) >= exportBatch
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			e.flush()
+			return
+		case <-ticker.C:
+		case <-e.kick:
+		}
+		e.flush()
+	}
+}
+
+// Shutdown exports the spans still queued and stops the exporter.
+func (e *Exporter) Shutdown() {
+	if e == nil {
+		return
+	}
+	close(e.stop)
+	<-e.done
+}
+
+func (e *Exporter) flush() {
+	for {
+		e.mu.Lock()
+		batch := e.queue[:min(len(e.queue), exportBatch)]
+		e.queue = e.queue[len(batch):]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+		if dropped > 0 {
+			logging.Or(e.Logger).Warn("trace export queue full, spans dropped", "dropped", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			logging.Or(e.Logger).Warn("trace export", "spans", len(batch), logging.Err(err))
+			return
+		}
+	}
+}
+
+func (e *Exporter) export(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("collector: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// OTLP JSON encoding, see opentelemetry-proto's trace.proto. IDs are hex and
+// 64-bit integers are decimal strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		Status            otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"` // 2 is error
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// request groups spans by the service of the tracer that started them.
+func (e *Exporter) request(spans []*Span) otlpRequest {
+	var req otlpRequest
+	index := map[string]int{}
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != (SpanID{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttribute(a.key, a.value))
+		}
+		if s.failed {
+			o.Status = otlpStatus{Code: 2, Message: s.errMsg}
+		}
+		s.mu.Unlock()
+
+		i, ok := index[s.tracer.service]
+		if !ok {
+			i = len(req.ResourceSpans)
+			index[s.tracer.service] = i
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource:   otlpResource{Attributes: []otlpAttr{otlpAttribute("service.name", s.tracer.service)}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "got"}}},
+			})
+		}
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, o)
+	}
+	return req
+}
+
+func otlpAttribute(key string, value any) otlpAttr {
+	a := otlpAttr{Key: key}
+	switch v := value.(type) {
+	case string:
+		a.Value.StringValue = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &s
+	case bool:
+		a.Value.BoolValue = &v
+	case float64:
+		a.Value.DoubleValue = &v
+	}
+	return a
+}
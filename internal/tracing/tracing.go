@@ -0,0 +1,217 @@
+// Package tracing records spans for visitor connections and exports them to
+// an OpenTelemetry collector over OTLP/HTTP with JSON encoding. Trace
+// context travels between server and client as W3C traceparent strings.
+//
+// A nil *Tracer is valid and records nothing, and the nil *Span it returns
+// is safe to use, so callers need no checks when tracing is off.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// SpanContext is the part of a span that crosses process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether sc has non-zero IDs.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as a W3C traceparent value, or "" if invalid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent value such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Span kinds, as numbered by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Tracer starts spans and hands finished, sampled ones to its exporter.
+type Tracer struct {
+	service  string
+	exporter *Exporter
+	ratio    float64
+}
+
+// New returns a tracer for service exporting through e. sampleRatio is the
+// fraction of new traces recorded; traces continued from a traceparent
+// follow its sampled flag.
+func New(service string, e *Exporter, sampleRatio float64) *Tracer {
+	return &Tracer{service: service, exporter: e, ratio: sampleRatio}
+}
+
+// Start begins a span named name. It continues parent if valid, and starts
+// a new trace otherwise.
+func (t *Tracer) Start(name string, kind int, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		s.sc.TraceID, s.sc.Sampled = parent.TraceID, parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	_, _ = rand.Read(s.sc.SpanID[:])
+	return s
+}
+
+// sample decides from the trace ID, so every span of a trace agrees.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	var n uint64
+	for _, b := range id[8:] {
+		n = n<<8 | uint64(b)
+	}
+	return float64(n>>11)/(1<<53) < t.ratio
+}
+
+// Span is one timed operation. Its methods are safe for concurrent use and
+// on a nil *Span.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   int
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []attr
+	errMsg string
+	failed bool
+}
+
+type attr struct {
+	key   string
+	value any // string, int64, bool or float64
+}
+
+// Context returns the span's context for propagation, or the zero
+// SpanContext for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// Traceparent is Context().Traceparent().
+func (s *Span) Traceparent() string { return s.Context().Traceparent() }
+
+// SetAttr records an attribute. Values other than strings, integers, bools
+// and floats are formatted with %v.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string, int64, bool, float64:
+	case int:
+		value = int64(v)
+	default:
+		value = fmt.Sprint(v)
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attr{key, value})
+	s.mu.Unlock()
+}
+
+// RecordError marks the span failed with err, if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.errMsg = true, err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export if sampled. Calls after
+// the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.exporter.add(s)
+	}
+}
+
+type spanKey struct{}
+
+// ContextWith returns ctx carrying span, for code that receives a context
+// rather than the span itself.
+func ContextWith(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext returns the span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	for _, tc := range []struct {
+		in      string
+		ok      bool
+		sampled bool
+	}{
+		{in: "00-" + traceID + "-" + spanID + "-01", ok: true, sampled: true},
+		{in: "00-" + traceID + "-" + spanID + "-00", ok: true},
+		{in: " 00-" + traceID + "-" + spanID + "-03 ", ok: true, sampled: true},
+		// Later versions may append fields; the known ones still parse.
+		{in: "01-" + traceID + "-" + spanID + "-01-future", ok: true, sampled: true},
+		{in: "ff-" + traceID + "-" + spanID + "-01"},
+		{in: "00-00000000000000000000000000000000-" + spanID + "-01"},
+		{in: "00-" + traceID + "-0000000000000000-01"},
+		{in: "00-" + traceID + "-" + spanID},
+		{in: "00-" + traceID[1:] + "-" + spanID + "-01"},
+		{in: "00-" + traceID + "-" + spanID + "-1"},
+		{in: "00-zzf92f3577b34da6a3ce929d0e0e4736-" + spanID + "-01"},
+		{in: "00-" + traceID + "-" + spanID + "-zz"},
+		{in: ""},
+	} {
+		sc, ok := ParseTraceparent(tc.in)
+		if ok != tc.ok || ok && sc.Sampled != tc.sampled {
+			t.Errorf("ParseTraceparent(%q) = %+v, %v; want ok %v, sampled %v", tc.in, sc, ok, tc.ok, tc.sampled)
+			continue
+		}
+		if ok && (sc.Traceparent()[3:52] != traceID+"-"+spanID) {
+			t.Errorf("ParseTraceparent(%q) round trips to %q", tc.in, sc.Traceparent())
+		}
+	}
+	if got := (SpanContext{}).Traceparent(); got != "" {
+		t.Errorf("zero SpanContext formats as %q", got)
+	}
+}
+
+func TestExportOTLP(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		bodies <- b
+	}))
+	defer collector.Close()
+
+	e := NewExporter(collector.URL + "/")
+	server := New("got-server", e, 1)
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	span := server.Start("visitor", KindServer, parent)
+	span.SetAttr("http.status_code", 502)
+	span.SetAttr("got.paused", true)
+	span.SetAttr("got.ratio", 0.5)
+	span.SetAttr("got.wait", 2*time.Second)
+	span.RecordError(errors.New("dial local: refused"))
+	span.End()
+	span.End()
+	New("got-client", e, 1).Start("dial_local", KindClient, span.Context()).End()
+	// Spans of an unsampled trace are never exported.
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	server.Start("dropped", KindServer, unsampled).End()
+	e.Shutdown()
+
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	body := <-bodies
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 2 {
+		t.Fatalf("request %s, want one resource per service", body)
+	}
+	service := func(i int) any {
+		attr := req.ResourceSpans[i].Resource.Attributes[0]
+		return attr["value"].(map[string]any)["stringValue"]
+	}
+	if service(0) != "got-server" || service(1) != "got-client" {
+		t.Fatalf("services %v and %v", service(0), service(1))
+	}
+
+	visitor := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(visitor) != 1 {
+		t.Fatalf("server spans = %v, want the sampled one only", visitor)
+	}
+	s := visitor[0]
+	if s["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" || s["parentSpanId"] != "00f067aa0ba902b7" ||
+		s["name"] != "visitor" || s["kind"] != float64(KindServer) {
+		t.Fatalf("span = %v", s)
+	}
+	if _, ok := s["startTimeUnixNano"].(string); !ok {
+		t.Fatalf("startTimeUnixNano = %#v, want a decimal string", s["startTimeUnixNano"])
+	}
+	if status := s["status"].(map[string]any); status["code"] != float64(2) || status["message"] != "dial local: refused" {
+		t.Fatalf("status = %v", status)
+	}
+	want := map[string]string{
+		"http.status_code": `{"intValue":"502"}`,
+		"got.paused":       `{"boolValue":true}`,
+		"got.ratio":        `{"doubleValue":0.5}`,
+		"got.wait":         `{"stringValue":"2s"}`,
+	}
+	for _, a := range s["attributes"].([]any) {
+		a := a.(map[string]any)
+		v, _ := json.Marshal(a["value"])
+		if key := a["key"].(string); string(v) != want[key] {
+			t.Errorf("attribute %s = %s, want %s", key, v, want[key])
+		}
+		delete(want, a["key"].(string))
+	}
+	if len(want) != 0 {
+		t.Errorf("attributes missing: %v", want)
+	}
+
+	client := req.ResourceSpans[1].ScopeSpans[0].Spans[0]
+	if client["parentSpanId"] != s["spanId"] || client["traceId"] != s["traceId"] {
+		t.Fatalf("client span %v does not continue %v", client, s)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// makeRaw switches the console to raw input with escape sequences for
+// special keys, and enables escape sequence processing on out.
+func makeRaw(in, out *os.File) (restore func(), err error) {
+	inH, outH := windows.Handle(in.Fd()), windows.Handle(out.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(inH, &inMode); err != nil {
+		return nil, err
+	}
+	if err := windows.GetConsoleMode(outH, &outMode); err != nil {
+		return nil, err
+	}
+	raw := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_PROCESSED_INPUT|windows.ENABLE_LINE_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(inH, raw); err != nil {
+		return nil, err
+	}
+	_ = windows.SetConsoleMode(outH, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	return func() {
+		_ = windows.SetConsoleMode(inH, inMode)
+		_ = windows.SetConsoleMode(outH, outMode)
+	}, nil
+}
+
+// termSize returns the width and height of the console window on out.
+func termSize(out *os.File) (width, height int, err error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(out.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
@@ -0,0 +1,436 @@
+// Package tui is the full-screen dashboard behind `got --tui`: the tunnel's
+// URL and state, the latency to the server and a scrolling list of recent
+// HTTP requests, with keys to replay a request, copy the URL and pause the
+// tunnel. It draws with the ANSI helpers in internal/colors.
+package tui
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/humanize"
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+)
+
+// Dashboard limits
+const (
+	maxRequests    = 500             // requests kept for scrolling
+	messageTimeout = 8 * time.Second // how long a message stays in the footer
+	redrawInterval = 500 * time.Millisecond
+)
+
+// Dashboard renders a client's state. Wire Event to Client.Events and
+// Request to HTTPOptions.Requests, then call Run.
+type Dashboard struct {
+	c   *client.Client
+	in  *os.File
+	out *os.File
+
+	mu        sync.Mutex
+	requests  []client.Request // oldest first
+	selected  int              // index into requests
+	follow    bool             // keep the newest request selected
+	top       int              // rows scrolled past, newest first
+	message   string
+	messageAt time.Time
+	isError   bool
+
+	dirty chan struct{}
+}
+
+// New returns a dashboard for c drawing on the process's terminal.
+func New(c *client.Client) *Dashboard {
+	return &Dashboard{c: c, in: os.Stdin, out: os.Stdout, follow: true, dirty: make(chan struct{}, 1)}
+}
+
+// Event records a client event; use it as Client.Events.
+func (d *Dashboard) Event(ev client.Event) {
+	switch ev.Type {
+	case client.EventTunnelOpened:
+		d.say(false, "Tunnel established")
+	case client.EventReconnecting:
+		d.say(true, fmt.Sprintf("Tunnel lost (%s), reconnecting in %s", ev.Error, time.Duration(ev.RetryInMS)*time.Millisecond))
+	case client.EventError:
+		d.say(true, ev.Error)
+	case client.EventQuotaExceeded:
+		d.say(true, "Server: "+ev.Error)
+	case client.EventHealth:
+		if !*ev.Healthy {
+			d.say(true, fmt.Sprintf("Health check failed (%d/%d): %s", ev.Failures, ev.Threshold, ev.Error))
+		}
+	}
+	d.redraw()
+}
+
+// Request adds a finished request to the list; use it as
+// HTTPOptions.Requests.
+func (d *Dashboard) Request(r client.Request) {
+	d.mu.Lock()
+	d.requests = append(d.requests, r)
+	if n := len(d.requests) - maxRequests; n > 0 {
+		d.requests = d.requests[n:]
+		d.selected = max(d.selected-n, 0)
+	}
+	if d.follow {
+		d.selected = len(d.requests) - 1
+	}
+	d.mu.Unlock()
+	d.redraw()
+}
+
+// Write shows the last log line written to it in the footer, so a logger
+// can point at the dashboard instead of the terminal it covers.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	if line := strings.TrimSpace(string(p)); line != "" {
+		if i := strings.LastIndexByte(line, '\n'); i >= 0 {
+			line = line[i+1:]
+		}
+		d.say(true, line)
+		d.redraw()
+	}
+	return len(p), nil
+}
+
+func (d *Dashboard) say(isError bool, msg string) {
+	d.mu.Lock()
+	d.message, d.messageAt, d.isError = msg, time.Now(), isError
+	d.mu.Unlock()
+}
+
+func (d *Dashboard) redraw() {
+	select {
+	case d.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Run takes over the terminal until ctx is done or the user quits, in which
+// case quit is called. The terminal is restored before Run returns.
+func (d *Dashboard) Run(ctx context.Context, quit func()) error {
+	restore, err := makeRaw(d.in, d.out)
+	if err != nil {
+		return fmt.Errorf("the dashboard needs a terminal: %w", err)
+	}
+	defer restore()
+	// Alternate screen, hidden cursor
+	fmt.Fprint(d.out, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(d.out, "\033[?25h\033[?1049l")
+
+	keys := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go readKeys(d.in, keys, done)
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-d.dirty:
+		case k := <-keys:
+			if k == "q" || k == "ctrl+c" {
+				quit()
+				return nil
+			}
+			d.key(ctx, k)
+		}
+	}
+}
+
+// readKeys sends each key read from in as "up", "down", "ctrl+c" or the
+// character itself, until in fails or done is closed. A Read blocked when
+// done closes returns with the next key, which is dropped.
+func readKeys(in io.Reader, keys chan<- string, done <-chan struct{}) {
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		for b := buf[:n]; len(b) > 0; {
+			var k string
+			switch {
+			case bytes.HasPrefix(b, []byte("\033[A")), bytes.HasPrefix(b, []byte("\033OA")):
+				k, b = "up", b[3:]
+			case bytes.HasPrefix(b, []byte("\033[B")), bytes.HasPrefix(b, []byte("\033OB")):
+				k, b = "down", b[3:]
+			case b[0] == 3:
+				k, b = "ctrl+c", b[1:]
+			case b[0] == '\033':
+				// Other escape sequences are ignored whole
+				b = nil
+				continue
+			default:
+				r, size := utf8.DecodeRune(b)
+				k, b = string(r), b[size:]
+			}
+			select {
+			case keys <- k:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+func (d *Dashboard) key(ctx context.Context, k string) {
+	switch k {
+	case "up", "k":
+		d.move(1)
+	case "down", "j":
+		d.move(-1)
+	case "r":
+		d.replay(ctx)
+	case "c":
+		d.copyURL()
+	case "p":
+		d.togglePause()
+	}
+}
+
+// togglePause pauses or resumes the tunnel.
+func (d *Dashboard) togglePause() {
+	if d.c.Paused() {
+		if err := d.c.Resume(); err != nil {
+			d.say(true, "Resume: "+err.Error())
+			return
+		}
+		d.say(false, "Tunnel resumed")
+		return
+	}
+	if err := d.c.Pause(""); err != nil {
+		d.say(true, "Pause: "+err.Error())
+		return
+	}
+	d.say(false, "Tunnel paused: visitors see a paused page, the URL stays yours")
+}
+
+// move selects a newer (by > 0) or older request.
+func (d *Dashboard) move(by int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.requests) == 0 {
+		return
+	}
+	d.selected = min(max(d.selected+by, 0), len(d.requests)-1)
+	d.follow = d.selected == len(d.requests)-1
+}
+
+func (d *Dashboard) replay(ctx context.Context) {
+	d.mu.Lock()
+	if len(d.requests) == 0 {
+		d.mu.Unlock()
+		return
+	}
+	r := d.requests[d.selected]
+	d.mu.Unlock()
+	d.say(false, fmt.Sprintf("Replaying %s %s...", r.Method, r.URI))
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		out, err := d.c.Replay(ctx, r)
+		if err != nil {
+			d.say(true, fmt.Sprintf("Replay %s %s: %v", r.Method, r.URI, err))
+		} else {
+			d.say(false, fmt.Sprintf("Replayed %s %s: %d in %s", r.Method, r.URI, out.Status, formatDuration(out.Duration)))
+		}
+		d.redraw()
+	}()
+}
+
+// copyURL puts the tunnel URL on the clipboard with an OSC 52 sequence,
+// which most terminals support, including over SSH.
+func (d *Dashboard) copyURL() {
+	url := d.c.Status().URL
+	if url == "" {
+		d.say(true, "No URL yet")
+		return
+	}
+	fmt.Fprintf(d.out, "\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(url)))
+	d.say(false, "Copied "+url)
+}
+
+// draw renders the whole screen in one write.
+func (d *Dashboard) draw() {
+	width, height, err := termSize(d.out)
+	if err != nil || width < 20 || height < 12 {
+		width, height = max(width, 80), max(height, 24)
+	}
+	st := d.c.Status()
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	line := func(s string) {
+		b.WriteString(s)
+		b.WriteString("\033[K\r\n")
+	}
+	field := func(label, value string) {
+		line("  " + colors.Gray(fmt.Sprintf("%-12s", label)) + value)
+	}
+
+	title := colors.Bold(colors.BrightCyan(" got ")) + " " + stateLabel(st.State)
+	if st.Paused {
+		title += " " + colors.Bold(colors.Yellow("PAUSED"))
+	}
+	line(title)
+	line("")
+	url := st.URL
+	if url == "" {
+		url = "waiting for the server..."
+	}
+	field("URL", colors.Bold(colors.BrightGreen(truncate(url, width-14))))
+	field("Forwarding", truncate("→ "+st.LocalAddr, width-14))
+	latency := colors.Gray("–")
+	if st.LatencyMS > 0 {
+		latency = fmt.Sprintf("%.1f ms", st.LatencyMS)
+	}
+	field("Latency", latency)
+	switch st.Upstream {
+	case client.UpstreamUp:
+		field("Local app", colors.Green("up"))
+	case client.UpstreamDown:
+		field("Local app", colors.Red("down"))
+	default:
+		field("Local app", colors.Gray("–"))
+	}
+	if t := st.Traffic; t != nil {
+		field("Visitors", truncate(fmt.Sprintf("%d active · %d requests · %s in · %s out · %d errors",
+			t.ActiveConns, t.Requests, humanize.Bytes(t.BytesIn), humanize.Bytes(t.BytesOut), t.Errors), width-14))
+	} else {
+		field("Visitors", fmt.Sprintf("%d active · %d total", st.ActiveConns, st.TotalConns))
+	}
+	line("")
+
+	// Request list, newest first
+	const fixed = "  › 15:04:05  METHOD   STATUS  DURATION  "
+	pathWidth := max(width-utf8.RuneCountInString(fixed), 10)
+	line(colors.Bold(fmt.Sprintf("    %-8s  %-7s  %-6s  %-8s  %s", "TIME", "METHOD", "STATUS", "DURATION", "PATH")))
+	rows := max(height-12, 1) // less the lines above and below the list
+
+	d.mu.Lock()
+	n := len(d.requests)
+	sel := n - 1 - d.selected // row of the selection, newest first
+	if sel < d.top {
+		d.top = sel
+	} else if sel >= d.top+rows {
+		d.top = sel - rows + 1
+	}
+	d.top = max(min(d.top, n-rows), 0)
+	for i := range rows {
+		row := d.top + i
+		switch {
+		case row < n:
+			line(requestRow(d.requests[n-1-row], row == sel, pathWidth))
+		case n == 0 && i == 0:
+			line(colors.Gray("    Waiting for requests. Open the URL above to see them here."))
+		default:
+			line("")
+		}
+	}
+	message, isError := d.message, d.isError
+	if time.Since(d.messageAt) > messageTimeout {
+		message = ""
+	}
+	d.mu.Unlock()
+
+	d.footer(line, width, message, isError, st)
+	// No newline after the last line, which would scroll the screen
+	_, _ = d.out.WriteString(strings.TrimSuffix(b.String(), "\r\n") + "\033[J")
+}
+
+// footer writes the message and key help lines.
+func (d *Dashboard) footer(line func(string), width int, message string, isError bool, st client.Status) {
+	line("")
+	switch {
+	case message == "":
+		line("")
+	case isError:
+		line("  " + colors.Red(truncate(message, width-2)))
+	default:
+		line("  " + colors.Cyan(truncate(message, width-2)))
+	}
+	pause := "pause"
+	if st.Paused {
+		pause = "resume"
+	}
+	line(colors.Gray(truncate("  ↑↓ select  r replay  c copy URL  p "+pause+"  q quit", width)))
+}
+
+func requestRow(r client.Request, selected bool, pathWidth int) string {
+	marker := "  "
+	if selected {
+		marker = colors.BrightCyan("› ")
+	}
+	status := fmt.Sprintf("%-6s", "---")
+	if r.Status > 0 {
+		status = fmt.Sprintf("%-6d", r.Status)
+	}
+	path := r.URI
+	if r.Replay {
+		path += " (replay)"
+	}
+	row := fmt.Sprintf("%s%s  %-7s  %s  %-8s  %s", marker, r.Time.Format("15:04:05"), truncate(r.Method, 7),
+		statusColor(r.Status, status), formatDuration(r.Duration), truncate(path, pathWidth))
+	if selected {
+		return "  " + colors.Bold(row)
+	}
+	return "  " + row
+}
+
+func statusColor(code int, s string) string {
+	switch {
+	case code == 0 || code >= 500:
+		return colors.Red(s)
+	case code >= 400:
+		return colors.Yellow(s)
+	case code >= 300:
+		return colors.Cyan(s)
+	default:
+		return colors.Green(s)
+	}
+}
+
+func stateLabel(state string) string {
+	switch state {
+	case client.StateOnline:
+		return colors.Green(state)
+	case client.StateClosed:
+		return colors.Red(state)
+	default:
+		return colors.Yellow(state)
+	}
+}
+
+// truncate shortens s to n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
@@ -0,0 +1,46 @@
+package tui
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadKeys(t *testing.T) {
+	keys := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	// An unknown escape sequence drops the rest of the read.
+	go readKeys(strings.NewReader("\033[Ak\033OBé\x03\033[1;5Cx"), keys, done)
+	for _, want := range []string{"up", "k", "down", "é", "ctrl+c"} {
+		if got := <-keys; got != want {
+			t.Fatalf("key = %q, want %q", got, want)
+		}
+	}
+	select {
+	case k := <-keys:
+		t.Fatalf("extra key %q", k)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReadKeysStopsWhenDone(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	keys := make(chan string)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		readKeys(r, keys, done)
+		close(exited)
+	}()
+	// Nobody reads keys once the dashboard is gone.
+	close(done)
+	go func() { _, _ = io.WriteString(w, "q") }()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readKeys is stuck sending a key after done")
+	}
+}
@@ -3,13 +3,18 @@ package client
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/HeyRistaa/got/internal/colors"
 	"github.com/HeyRistaa/got/internal/protocol/control"
+	"github.com/HeyRistaa/got/internal/tracing"
 )
 
 type Client struct {
@@ -17,89 +22,388 @@ type Client struct {
 	ServerData    string // server data listener address host:port (client will dial)
 	LocalAddr     string // local service address to forward, e.g., 127.0.0.1:3000
 	ClientID      string // optional label
+	Name          string // name shown by `got ls`; defaults to LocalAddr
+	Reconnect     bool   // retry with backoff when the control connection drops
+	Auth          string // optional "user:pass" the server demands from visitors (HTTP Basic)
+	Token         string // optional API token; the server rate limits per token
+
+	// Optional visitor IP lists (CIDRs or single IPs) enforced by the server
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	// Login, when set, asks the server to put its OIDC login gate in front
+	// of the tunnel, optionally narrowed to LoginEmailDomains.
+	Login             bool
+	LoginEmailDomains []string
+
+	// Health, when set, replaces the server's default health check policy.
+	Health *control.HealthPolicy
+
+	// HTTP, when non-nil, forwards data connections through the HTTP-aware
+	// path instead of a raw TCP pipe. See HTTPOptions.
+	HTTP *HTTPOptions
+
+	// Handler, when set, receives each visitor data connection instead of it
+	// being dialed through to LocalAddr. It owns the connection.
+	Handler func(net.Conn)
+
+	// Events, when set, receives lifecycle events instead of them being
+	// printed as colored text. See JSONEvents.
+	Events func(Event)
+
+	// Logger receives errors and per-connection records when Events is
+	// unset; nil logs to slog.Default().
+	Logger *slog.Logger
+
+	// Tracer, when set, records a span per visitor connection, joined to the
+	// server's trace through the ConnRequest traceparent.
+	Tracer *tracing.Tracer
+
+	// PingInterval is how often the round trip to the server is measured
+	// with heartbeats, see Status.LatencyMS. Zero disables it.
+	PingInterval time.Duration
+
+	paused  atomic.Pointer[string]  // pause message; nil while forwarding
+	session atomic.Pointer[Session] // the open session, if any
+
+	stats stats
 }
 
+// Reconnect backoff bounds
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// RefusedError is returned by Open when the server answers with tunnel_error.
+type RefusedError struct {
+	Reason     string
+	RetryAfter time.Duration // set when the server is rate limiting the client
+	Permanent  bool          // set when retrying cannot succeed, e.g. a permanent ban
+}
+
+func (e *RefusedError) Error() string { return "server refused tunnel: " + e.Reason }
+
 func New(serverControl, serverData, localAddr, clientID, domain string) *Client {
 	return &Client{ServerControl: serverControl, ServerData: serverData, LocalAddr: localAddr, ClientID: clientID}
 }
 
+// Session is a tunnel opened on the server. It stays open until Close is
+// called or the server drops the control connection.
+type Session struct {
+	Opened control.TunnelOpened
+
+	conn net.Conn
+	done chan struct{}
+
+	wmu sync.Mutex // serializes messages to the server
+
+	pingSeq  atomic.Int64 // heartbeat awaiting its echo
+	pingSent atomic.Int64 // when it was sent, in Unix nanoseconds
+}
+
+// URL returns the public URL of the tunnel.
+func (s *Session) URL() string {
+	if s.Opened.PublicHost != "" {
+		return "https://" + s.Opened.PublicHost
+	}
+	return "http://" + s.Opened.PublicAddr
+}
+
+// Done is closed once the control connection is gone.
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+// send writes a message to the server on the control connection.
+func (s *Session) send(v any) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return control.WriteJSONLine(s.conn, v)
+}
+
+// Close tears down the control connection, which closes the tunnel server-side.
+func (s *Session) Close() error { return s.conn.Close() }
+
+// Run keeps the tunnel open until ctx is done. When Reconnect is set, a lost
+// or failed control connection is retried with exponential backoff, except
+// for refusals the server marks permanent; otherwise the first failure is
+// returned.
 func (c *Client) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		online, err := c.runOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var refused *RefusedError
+		isRefused := errors.As(err, &refused)
+		if !c.Reconnect || (isRefused && refused.Permanent) {
+			return err
+		}
+		if online {
+			backoff = minBackoff
+		}
+		wait := backoff
+		if isRefused && refused.RetryAfter > wait {
+			wait = refused.RetryAfter
+		}
+		c.stats.setError(err)
+		c.emit(Event{Type: EventReconnecting, Error: err.Error(), RetryInMS: wait.Milliseconds()})
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// runOnce opens a single session and blocks until it ends. online reports
+// whether the tunnel was established at all.
+func (c *Client) runOnce(ctx context.Context) (online bool, err error) {
+	sess, err := c.Open(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer sess.Close()
+	defer c.stats.setState(StateClosed, "", "")
+	defer c.emit(Event{Type: EventTunnelClosed, TunnelID: sess.Opened.TunnelID})
+
+	select {
+	case <-ctx.Done():
+		return true, nil
+	case <-sess.Done():
+		return true, fmt.Errorf("control connection closed by server")
+	}
+}
+
+// handshakeErr returns ctx's error in place of err when ctx ended the
+// handshake by setting a deadline.
+func handshakeErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Open establishes the control connection, waits for tunnel_opened and then
+// serves ConnRequests in the background until the session ends.
+func (c *Client) Open(ctx context.Context) (*Session, error) {
+	c.stats.setState(StateConnecting, "", "")
+
 	// Establish control connection
-	ctlConn, err := net.Dial("tcp", c.ServerControl)
+	var d net.Dialer
+	ctlConn, err := d.DialContext(ctx, "tcp", c.ServerControl)
 	if err != nil {
-		return fmt.Errorf("dial control: %w", err)
+		err = fmt.Errorf("dial control: %w", err)
+		c.stats.setError(err)
+		return nil, err
 	}
-	defer ctlConn.Close()
+
+	// The handshake below blocks on the server; ctx bounds it.
+	stop := context.AfterFunc(ctx, func() { _ = ctlConn.SetDeadline(time.Now()) })
 
 	// Send open_tunnel request
 	localURL := "http://" + c.LocalAddr
-	if err := control.WriteJSONLine(ctlConn, control.OpenTunnel{
+	req := control.OpenTunnel{
 		Type:      "open_tunnel",
 		ClientID:  c.ClientID,
+		Token:     c.Token,
 		LocalHint: c.LocalAddr,
 		LocalURL:  localURL,
-	}); err != nil {
-		return err
+
+		AllowCIDRs: c.AllowCIDRs,
+		DenyCIDRs:  c.DenyCIDRs,
+	}
+	req.Health = c.Health
+	if c.Login {
+		req.OIDC = &control.OIDCGate{EmailDomains: c.LoginEmailDomains}
+	}
+	if c.Auth != "" {
+		user, pass, _ := strings.Cut(c.Auth, ":")
+		req.BasicAuth = &control.BasicAuth{Username: user, Password: pass}
+	}
+	if err := control.WriteJSONLine(ctlConn, req); err != nil {
+		stop()
+		ctlConn.Close()
+		return nil, handshakeErr(ctx, err)
 	}
 
 	// Await tunnel_opened
 	r := bufio.NewReader(ctlConn)
-	var opened control.TunnelOpened
-	if err := control.ReadJSONLine(r, &opened); err != nil {
-		return fmt.Errorf("read opened: %w", err)
+	var reply struct {
+		control.TunnelOpened
+		Error      string `json:"error"`       // set on tunnel_error
+		RetryAfter int    `json:"retry_after"` // set on tunnel_error
+		Permanent  bool   `json:"permanent"`   // set on tunnel_error
+	}
+	err = control.ReadJSONLine(r, &reply)
+	if !stop() && err == nil {
+		err = ctx.Err()
 	}
+	if err != nil {
+		ctlConn.Close()
+		return nil, fmt.Errorf("read opened: %w", handshakeErr(ctx, err))
+	}
+	_ = ctlConn.SetDeadline(time.Time{})
+	opened := reply.TunnelOpened
 	if opened.Type != "tunnel_opened" {
-		return fmt.Errorf("unexpected message: %v", opened.Type)
+		ctlConn.Close()
+		if opened.Type == "tunnel_error" {
+			return nil, &RefusedError{Reason: reply.Error, RetryAfter: time.Duration(reply.RetryAfter) * time.Second, Permanent: reply.Permanent}
+		}
+		return nil, fmt.Errorf("unexpected message: %v", opened.Type)
 	}
+
+	sess := &Session{Opened: opened, conn: ctlConn, done: make(chan struct{})}
+	c.stats.setState(StateOnline, sess.URL(), opened.TunnelID)
+	ev := Event{Type: EventTunnelOpened, TunnelID: opened.TunnelID, PublicAddr: opened.PublicAddr}
 	if opened.PublicHost != "" {
-		colors.PrintfSuccess("Tunnel established: %s -> %s\n", colors.Cyan(c.LocalAddr), colors.BrightCyan(opened.PublicAddr))
-		colors.PrintfGlobe("Your service is now available at: %s\n", colors.Bold(colors.BrightGreen("https://"+opened.PublicHost)))
-		colors.PrintInfo("Press Ctrl+C to stop the tunnel\n")
-	} else {
-		colors.PrintfSuccess("Tunnel established: %s -> %s\n", colors.Cyan(c.LocalAddr), colors.BrightCyan(opened.PublicAddr))
-		colors.PrintInfo("Press Ctrl+C to stop the tunnel\n")
+		ev.URL = sess.URL()
+	}
+	c.emit(ev)
+
+	// A pause outlives the session: tell the new tunnel about it.
+	c.session.Store(sess)
+	if msg := c.paused.Load(); msg != nil {
+		_ = sess.send(control.PauseTunnel{Type: "pause_tunnel", TunnelID: opened.TunnelID, Message: *msg})
 	}
 
-	// Start a goroutine to listen for ConnRequest on control, and then dial server data
+	// Listen for ConnRequest on control, and then dial server data. The same
+	// reader is reused so frames buffered after tunnel_opened are not lost.
 	go func() {
-		r := bufio.NewReader(ctlConn)
+		defer close(sess.done)
+		defer c.stats.setState(StateClosed, "", "")
+		defer c.session.CompareAndSwap(sess, nil)
 		for {
-			var cr control.ConnRequest
-			if err := control.ReadJSONLine(r, &cr); err != nil {
+			var msg json.RawMessage
+			if err := control.ReadJSONLine(r, &msg); err != nil {
 				return
 			}
-			if cr.Type != "conn_request" {
-				continue
+			var hdr struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(msg, &hdr)
+			switch hdr.Type {
+			case "conn_request":
+				var cr control.ConnRequest
+				if json.Unmarshal(msg, &cr) == nil {
+					// For each request, dial server's data and send DataInit, then pipe to local
+					go c.openDataAndPipe(sess, cr)
+				}
+			case "health_report":
+				var hr control.HealthReport
+				if json.Unmarshal(msg, &hr) == nil {
+					c.healthReport(hr)
+				}
+			case "heartbeat":
+				var hb control.Heartbeat
+				if json.Unmarshal(msg, &hb) == nil && hb.Seq == sess.pingSeq.Load() {
+					c.stats.setLatency(time.Since(time.Unix(0, sess.pingSent.Load())))
+				}
+			case "tunnel_stats":
+				var ts control.TunnelStats
+				if json.Unmarshal(msg, &ts) == nil {
+					c.tunnelStats(ts)
+				}
+			case "quota_exceeded":
+				var q control.QuotaExceeded
+				if json.Unmarshal(msg, &q) == nil {
+					c.stats.setError(errors.New(q.Message))
+					c.emit(Event{Type: EventQuotaExceeded, TunnelID: q.TunnelID, Error: q.Message})
+				}
 			}
-			// For each request, dial server's data and send DataInit, then pipe to local
-			go c.openDataAndPipe(cr)
 		}
 	}()
+	if c.Handler == nil {
+		go c.watchUpstream(sess)
+	}
+	if c.PingInterval > 0 {
+		go c.ping(sess)
+	}
 
-	<-ctx.Done()
-	return nil
+	return sess, nil
 }
 
-func (c *Client) openDataAndPipe(cr control.ConnRequest) {
+func (c *Client) openDataAndPipe(sess *Session, cr control.ConnRequest) {
+	parent, _ := tracing.ParseTraceparent(cr.Traceparent)
+	span := c.Tracer.Start("conn", tracing.KindServer, parent)
+	defer span.End()
+	span.SetAttr("got.tunnel_id", cr.TunnelID)
+	span.SetAttr("got.conn_id", cr.ConnID)
+
 	// Dial server data listener
-	conn, err := net.DialTimeout("tcp", c.ServerData, 5*time.Second)
+	dial := c.Tracer.Start("dial_server", tracing.KindClient, span.Context())
+	dial.SetAttr("server.address", c.ServerData)
+	dataConn, err := net.DialTimeout("tcp", c.ServerData, 5*time.Second)
 	if err != nil {
-		log.Printf("dial server data %s: %v", c.ServerData, err)
+		err = fmt.Errorf("dial server data %s: %w", c.ServerData, err)
+		dial.RecordError(err)
+		dial.End()
+		span.RecordError(err)
+		c.fail(err, cr.ConnID)
 		return
 	}
 	// Send DataInit to match the server's pending request
-	if err := control.WriteJSONLine(conn, control.DataInit{Type: "data_init", TunnelID: cr.TunnelID, ConnID: cr.ConnID}); err != nil {
-		log.Printf("write data_init: %v", err)
+	err = control.WriteJSONLine(dataConn, control.DataInit{Type: "data_init", TunnelID: cr.TunnelID, ConnID: cr.ConnID, Traceparent: dial.Traceparent()})
+	dial.RecordError(err)
+	dial.End()
+	if err != nil {
+		err = fmt.Errorf("write data_init: %w", err)
+		span.RecordError(err)
+		c.fail(err, cr.ConnID)
+		dataConn.Close()
+		return
+	}
+	c.stats.totalConns.Add(1)
+	conn := &countingConn{Conn: dataConn, in: &c.stats.bytesIn, out: &c.stats.bytesOut}
+	defer func() {
+		span.SetAttr("got.bytes_in", conn.nIn.Load())
+		span.SetAttr("got.bytes_out", conn.nOut.Load())
+	}()
+
+	if c.Handler != nil {
+		c.Handler(conn)
+		return
+	}
+	c.stats.activeConns.Add(1)
+	defer c.stats.activeConns.Add(-1)
+	c.emit(Event{Type: EventConnOpened, TunnelID: cr.TunnelID, ConnID: cr.ConnID})
+	defer func() {
+		c.emit(Event{Type: EventConnClosed, TunnelID: cr.TunnelID, ConnID: cr.ConnID, BytesIn: conn.nIn.Load(), BytesOut: conn.nOut.Load()})
+	}()
+
+	if msg := c.paused.Load(); msg != nil {
+		span.SetAttr("got.paused", true)
+		if c.HTTP != nil {
+			serveOffline(conn, msg)
+		}
 		conn.Close()
 		return
 	}
+
 	// Connect to local app
+	dial = c.Tracer.Start("dial_local", tracing.KindClient, span.Context())
+	dial.SetAttr("server.address", c.LocalAddr)
 	localConn, err := net.DialTimeout("tcp", c.LocalAddr, 5*time.Second)
+	dial.RecordError(err)
+	dial.End()
+	c.upstreamState(sess, err)
 	if err != nil {
-		log.Printf("dial local %s: %v", c.LocalAddr, err)
+		err = fmt.Errorf("dial local %s: %w", c.LocalAddr, err)
+		span.RecordError(err)
+		c.fail(err, cr.ConnID)
+		// Raw TCP visitors may never send a request line, so only HTTP
+		// tunnels wait for one to answer with the offline page.
+		if c.HTTP != nil {
+			serveOffline(conn, nil)
+		}
 		conn.Close()
 		return
 	}
+	if c.HTTP != nil {
+		c.HTTP.forward(conn, localConn, c.LocalAddr)
+		return
+	}
 	// Pipe both ways
 	pipe(localConn, conn)
 }
@@ -132,3 +436,84 @@ func ioCopy(dst, src net.Conn) (int64, error) {
 		}
 	}
 }
+
+// ping sends a heartbeat every PingInterval until the session ends; the
+// control loop times the server's echo.
+func (c *Client) ping(sess *Session) {
+	ticker := time.NewTicker(c.PingInterval)
+	defer ticker.Stop()
+	for {
+		seq := sess.pingSeq.Add(1)
+		sess.pingSent.Store(time.Now().UnixNano())
+		if sess.send(control.Heartbeat{Type: "heartbeat", TunnelID: sess.Opened.TunnelID, Seq: seq}) != nil {
+			return
+		}
+		select {
+		case <-sess.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pause stops forwarding visitors to the local app without closing the
+// tunnel: the server answers them with its maintenance page, showing
+// message, and the URL stays reserved. The pause is kept across
+// reconnects until Resume.
+func (c *Client) Pause(message string) error {
+	c.paused.Store(&message)
+	sess := c.session.Load()
+	if sess == nil {
+		return nil
+	}
+	return sess.send(control.PauseTunnel{Type: "pause_tunnel", TunnelID: sess.Opened.TunnelID, Message: message})
+}
+
+// Resume undoes Pause.
+func (c *Client) Resume() error {
+	if c.paused.Swap(nil) == nil {
+		return nil
+	}
+	sess := c.session.Load()
+	if sess == nil {
+		return nil
+	}
+	return sess.send(control.ResumeTunnel{Type: "resume_tunnel", TunnelID: sess.Opened.TunnelID})
+}
+
+// Paused reports whether the tunnel is paused, see Pause.
+func (c *Client) Paused() bool { return c.paused.Load() != nil }
+
+// tunnelStats records the server's traffic counters and reports them.
+func (c *Client) tunnelStats(ts control.TunnelStats) {
+	t := &Traffic{
+		ActiveConns:   ts.ActiveConns,
+		TotalConns:    ts.TotalConns,
+		Requests:      ts.Requests,
+		BytesIn:       ts.BytesIn,
+		BytesOut:      ts.BytesOut,
+		Errors:        ts.Errors,
+		LastVisitorIP: ts.LastVisitorIP,
+		UpdatedAt:     time.Now(),
+	}
+	c.stats.setTraffic(t)
+	c.emit(Event{Type: EventStats, TunnelID: ts.TunnelID, Traffic: t})
+}
+
+// healthReport records a server health check and reports failures and
+// recoveries.
+func (c *Client) healthReport(r control.HealthReport) {
+	prev := c.stats.setHealth(r)
+	if r.OK && prev != HealthUnhealthy {
+		return
+	}
+	c.emit(Event{
+		Type:      EventHealth,
+		TunnelID:  r.TunnelID,
+		Healthy:   &r.OK,
+		Status:    r.Status,
+		Error:     r.Error,
+		Failures:  r.Failures,
+		Threshold: r.Threshold,
+	})
+}
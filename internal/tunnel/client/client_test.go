@@ -0,0 +1,170 @@
+package client_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HeyRistaa/got/gottest"
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+	"github.com/HeyRistaa/got/internal/tunnel/server"
+)
+
+func TestRunStopsOnPermanentRefusal(t *testing.T) {
+	srv, err := gottest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	for name, setup := range map[string]func(c *client.Client){
+		"permanent ban": func(c *client.Client) {
+			if _, err := srv.Server.Bans.Add(server.Ban{Kind: server.BanClientID, Value: "banned"}); err != nil {
+				t.Fatal(err)
+			}
+			c.ClientID = "banned"
+		},
+		"invalid auth": func(c *client.Client) { c.Auth = ":secret" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := client.New(srv.ControlAddr, srv.DataAddr, "127.0.0.1:1", "bob", "")
+			c.Reconnect = true
+			setup(c)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := c.Run(ctx)
+			var refused *client.RefusedError
+			if !errors.As(err, &refused) || !refused.Permanent {
+				t.Fatalf("Run = %v, want a permanent RefusedError", err)
+			}
+			if ctx.Err() != nil {
+				t.Fatal("Run kept retrying until the deadline")
+			}
+		})
+	}
+}
+
+func TestPauseAnswersVisitorsWithoutTheClient(t *testing.T) {
+	srv, err := gottest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	var served atomic.Int64
+	opened := make(chan string, 1)
+	c := client.New(srv.ControlAddr, srv.DataAddr, "127.0.0.1:1", "bob", "")
+	c.Handler = func(conn net.Conn) {
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		served.Add(1)
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+	}
+	c.Events = func(ev client.Event) {
+		if ev.Type == client.EventTunnelOpened {
+			opened <- "http://" + ev.PublicAddr
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+	url := <-opened
+
+	status := func() int {
+		hc := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+		resp, err := hc.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// waitStatus polls until the server has handled the pause or resume.
+	waitStatus := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for status() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("visitors never got %d", want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitStatus(http.StatusOK)
+	if err := c.Pause("deploying"); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(http.StatusServiceUnavailable)
+	before := served.Load()
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Fatalf("paused tunnel = %d, want 503", code)
+	}
+	if n := served.Load(); n != before {
+		t.Fatalf("client served %d visitors while paused", n-before)
+	}
+	if err := c.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(http.StatusOK)
+}
+
+func TestLocalAppDown(t *testing.T) {
+	srv, err := gottest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	open := func(t *testing.T, httpOpts *client.HTTPOptions) string {
+		t.Helper()
+		opened := make(chan string, 1)
+		c := client.New(srv.ControlAddr, srv.DataAddr, "127.0.0.1:1", "bob", "")
+		c.HTTP = httpOpts
+		c.Events = func(ev client.Event) {
+			if ev.Type == client.EventTunnelOpened {
+				opened <- ev.PublicAddr
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go func() { _ = c.Run(ctx) }()
+		return <-opened
+	}
+
+	t.Run("http", func(t *testing.T) {
+		addr := open(t, &client.HTTPOptions{})
+		hc := &http.Client{Timeout: 5 * time.Second}
+		resp, err := hc.Get("http://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("offline tunnel = %d, want 502", resp.StatusCode)
+		}
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		// A raw visitor that waits for the server to speak first is closed
+		// at once instead of waiting out the offline page's read timeout.
+		conn, err := net.Dial("tcp", open(t, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+			t.Fatalf("Read = %d, %v, want the connection closed", n, err)
+		}
+	})
+}
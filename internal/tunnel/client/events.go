@@ -0,0 +1,167 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/colors"
+	"github.com/HeyRistaa/got/internal/humanize"
+	"github.com/HeyRistaa/got/internal/logging"
+)
+
+// Event types reported through Client.Events
+const (
+	EventTunnelOpened = "tunnel_opened"
+	EventTunnelClosed = "tunnel_closed"
+	EventConnOpened   = "connection_opened"
+	EventConnClosed   = "connection_closed"
+	EventError        = "error"
+	EventReconnecting = "reconnecting"
+
+	// EventQuotaExceeded reports that the server closed or paused the tunnel
+	// because a transfer quota was used up; Error says which and until when.
+	EventQuotaExceeded = "quota_exceeded"
+
+	// EventHealth reports a failed server health check, and the first
+	// passing one afterwards. Status and Failures describe the probe.
+	EventHealth = "health"
+
+	// EventUpstream reports that the local app stopped accepting
+	// connections (Up false, with the dial error) or started again.
+	EventUpstream = "upstream"
+
+	// EventStats carries the server's traffic counters in Traffic, sent
+	// every few seconds while they change.
+	EventStats = "stats"
+)
+
+// Event is a machine-readable notification about the tunnel
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Name       string    `json:"name,omitempty"`
+	TunnelID   string    `json:"tunnel_id,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	PublicAddr string    `json:"public_addr,omitempty"`
+	LocalAddr  string    `json:"local_addr,omitempty"`
+	ConnID     string    `json:"conn_id,omitempty"`
+	BytesIn    int64     `json:"bytes_in,omitempty"`
+	BytesOut   int64     `json:"bytes_out,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	RetryInMS  int64     `json:"retry_in_ms,omitempty"`
+
+	// Set on EventHealth
+	Healthy   *bool `json:"healthy,omitempty"`
+	Status    int   `json:"status,omitempty"`
+	Failures  int   `json:"failures,omitempty"`
+	Threshold int   `json:"threshold,omitempty"`
+
+	// Set on EventUpstream
+	Up *bool `json:"up,omitempty"`
+
+	// Set on EventStats
+	Traffic *Traffic `json:"traffic,omitempty"`
+}
+
+// JSONEvents returns an Events callback writing newline-delimited JSON to w.
+func JSONEvents(w io.Writer) func(Event) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(ev)
+	}
+}
+
+func (c *Client) emit(ev Event) {
+	ev.Time = time.Now()
+	ev.Name = c.name()
+	if ev.LocalAddr == "" {
+		ev.LocalAddr = c.LocalAddr
+	}
+	if c.Events != nil {
+		c.Events(ev)
+		return
+	}
+	log := logging.Or(c.Logger).With("name", ev.Name)
+	switch ev.Type {
+	case EventError:
+		log.Error(ev.Error, logging.ConnID, ev.ConnID)
+	case EventConnOpened:
+		log.Debug("visitor connected", logging.TunnelID, ev.TunnelID, logging.ConnID, ev.ConnID)
+	case EventConnClosed:
+		log.Debug("visitor disconnected", logging.TunnelID, ev.TunnelID, logging.ConnID, ev.ConnID,
+			"bytes_in", ev.BytesIn, "bytes_out", ev.BytesOut)
+	default:
+		printEvent(ev)
+	}
+}
+
+// fail records err as the tunnel's last error and reports it.
+func (c *Client) fail(err error, connID string) {
+	c.stats.setError(err)
+	c.emit(Event{Type: EventError, ConnID: connID, Error: err.Error()})
+}
+
+// printEvent is the human-readable rendering used when Events is unset.
+// Errors and per-connection events go to the Logger instead. Traffic is
+// shown on a live status line when the output is a terminal.
+func printEvent(ev Event) {
+	switch ev.Type {
+	case EventStats:
+		colors.SetStatusLine(trafficLine(ev.Traffic))
+	case EventTunnelClosed:
+		colors.SetStatusLine("")
+	case EventTunnelOpened:
+		colors.PrintfSuccess("Tunnel established: %s -> %s\n", colors.Cyan(ev.LocalAddr), colors.BrightCyan(ev.PublicAddr))
+		if ev.URL != "" {
+			colors.PrintfGlobe("Your service is now available at: %s\n", colors.Bold(colors.BrightGreen(ev.URL)))
+		}
+		colors.PrintInfo("Press Ctrl+C to stop the tunnel\n")
+	case EventReconnecting:
+		colors.PrintfWarning("Tunnel lost (%s), reconnecting in %s\n", ev.Error, time.Duration(ev.RetryInMS)*time.Millisecond)
+	case EventHealth:
+		if *ev.Healthy {
+			colors.PrintSuccess("Health check passing again\n")
+		} else {
+			colors.PrintfWarning("Health check failed (%d/%d): %s\n", ev.Failures, ev.Threshold, ev.Error)
+		}
+	case EventUpstream:
+		if *ev.Up {
+			colors.PrintfSuccess("Local app at %s is responding again\n", colors.Cyan(ev.LocalAddr))
+		} else {
+			colors.PrintfWarning("Local app at %s is not responding; visitors see an offline page\n", colors.Cyan(ev.LocalAddr))
+		}
+	case EventQuotaExceeded:
+		colors.PrintfWarning("Server: %s\n", ev.Error)
+	}
+}
+
+// trafficLine renders t for the status line.
+func trafficLine(t *Traffic) string {
+	parts := []string{fmt.Sprintf("%d active", t.ActiveConns)}
+	if t.Requests > 0 {
+		parts = append(parts, fmt.Sprintf("%d requests", t.Requests))
+	} else {
+		parts = append(parts, fmt.Sprintf("%d connections", t.TotalConns))
+	}
+	parts = append(parts, humanize.Bytes(t.BytesIn)+" in", humanize.Bytes(t.BytesOut)+" out")
+	errs := fmt.Sprintf("%d errors", t.Errors)
+	if t.Errors > 0 {
+		errs = colors.Red(errs)
+	}
+	parts = append(parts, errs)
+	if t.LastVisitorIP != "" {
+		parts = append(parts, "last visitor "+t.LastVisitorIP)
+	}
+	dot := colors.Green("●")
+	if t.ActiveConns == 0 {
+		dot = colors.Gray("●")
+	}
+	return dot + " " + strings.Join(parts, colors.Gray(" · "))
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPOptions enables the HTTP-aware forwarding path. When set on a Client,
+// each data connection is parsed as a stream of HTTP/1.x requests so that the
+// Host header and configured headers can be rewritten before reaching the
+// local app. Tunnels without HTTPOptions keep the raw TCP pipe.
+type HTTPOptions struct {
+	// HostHeader controls the Host sent to the local app:
+	// ""        keep the public host (e.g. abc123.showapps.online)
+	// "rewrite" use the local address (e.g. localhost:3000)
+	// otherwise the value is used verbatim.
+	HostHeader string
+
+	RequestHeaders  HeaderRules // applied to requests before they reach the local app
+	ResponseHeaders HeaderRules // applied to responses before they go back to the visitor
+
+	// Requests, when set, is called with every request once its response
+	// has been relayed, e.g. to show recent traffic.
+	Requests func(Request)
+}
+
+// maxCapturedBody is how much of a request body Request keeps
+const maxCapturedBody = 64 << 10
+
+// Request is an HTTP request forwarded to the local app, as it was sent
+// after header rewriting, and the outcome.
+type Request struct {
+	Time          time.Time     `json:"time"`
+	Method        string        `json:"method"`
+	Host          string        `json:"host"`
+	URI           string        `json:"uri"`
+	Header        http.Header   `json:"header"`
+	Body          []byte        `json:"body,omitempty"`
+	BodyTruncated bool          `json:"body_truncated,omitempty"` // Body holds only the first 64 KiB
+	Status        int           `json:"status,omitempty"`         // 0 when the local app did not answer
+	Duration      time.Duration `json:"duration"`
+	Replay        bool          `json:"replay,omitempty"` // sent by Client.Replay rather than a visitor
+}
+
+// HeaderRules adds and removes HTTP headers. Removals run before additions so
+// a header can be replaced by listing it in both.
+type HeaderRules struct {
+	Add    http.Header
+	Remove []string
+}
+
+func (h HeaderRules) apply(hdr http.Header) {
+	for _, name := range h.Remove {
+		hdr.Del(name)
+	}
+	for name, values := range h.Add {
+		hdr.Del(name)
+		for _, v := range values {
+			hdr.Add(name, v)
+		}
+	}
+}
+
+// ParseHeader parses a "Name: value" pair as accepted by the CLI flags.
+func ParseHeader(s string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(value), true
+}
+
+func (o *HTTPOptions) rewriteRequest(req *http.Request, localAddr string) {
+	switch o.HostHeader {
+	case "":
+	case "rewrite":
+		o.setHost(req, localAddr)
+	default:
+		o.setHost(req, o.HostHeader)
+	}
+	// Keep net/http from injecting its own User-Agent when the visitor sent none.
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header["User-Agent"] = []string{""}
+	}
+	o.RequestHeaders.apply(req.Header)
+}
+
+func (o *HTTPOptions) setHost(req *http.Request, host string) {
+	if req.Header.Get("X-Forwarded-Host") == "" && req.Host != "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	req.Host = host
+}
+
+// forward relays HTTP/1.x requests read from remote to local and the matching
+// responses back, rewriting headers on the way. Protocol upgrades (e.g.
+// WebSocket) fall back to a raw pipe once the 101 response has been relayed.
+func (o *HTTPOptions) forward(remote, local net.Conn, localAddr string) {
+	defer remote.Close()
+	defer local.Close()
+
+	rr := bufio.NewReader(remote)
+	lr := bufio.NewReader(local)
+	for {
+		req, err := http.ReadRequest(rr)
+		if err != nil {
+			return
+		}
+		o.rewriteRequest(req, localAddr)
+		rec := o.record(req)
+		if err := req.Write(local); err != nil {
+			o.report(rec, 0)
+			return
+		}
+
+		resp, err := readFinalResponse(lr, req, remote)
+		if err != nil {
+			o.report(rec, 0)
+			return
+		}
+		o.ResponseHeaders.apply(resp.Header)
+
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			o.report(rec, resp.StatusCode)
+			if err := resp.Write(remote); err != nil {
+				return
+			}
+			pipeBuffered(remote, rr, local, lr)
+			return
+		}
+
+		err = resp.Write(remote)
+		resp.Body.Close()
+		o.report(rec, resp.StatusCode)
+		if err != nil || req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// capture is a request being recorded for Requests
+type capture struct {
+	Request
+	body *captureBody
+}
+
+// record starts capturing req when Requests is set.
+func (o *HTTPOptions) record(req *http.Request) *capture {
+	if o.Requests == nil {
+		return nil
+	}
+	c := &capture{Request: Request{
+		Time:   time.Now(),
+		Method: req.Method,
+		Host:   req.Host,
+		URI:    req.RequestURI,
+		Header: req.Header.Clone(),
+	}}
+	if req.Body != nil && req.Body != http.NoBody {
+		c.body = &captureBody{ReadCloser: req.Body}
+		req.Body = c.body
+	}
+	return c
+}
+
+// report hands a finished capture to Requests.
+func (o *HTTPOptions) report(c *capture, status int) {
+	if c == nil {
+		return
+	}
+	c.Status = status
+	c.Duration = time.Since(c.Time)
+	if c.body != nil {
+		c.Body, c.BodyTruncated = c.body.buf.Bytes(), c.body.truncated
+	}
+	o.Requests(c.Request)
+}
+
+// captureBody keeps the first maxCapturedBody bytes read through it.
+type captureBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	keep := min(n, maxCapturedBody-b.buf.Len())
+	b.buf.Write(p[:keep])
+	if keep < n {
+		b.truncated = true
+	}
+	return n, err
+}
+
+// Replay sends a captured request to the local app again and returns the
+// new outcome, marked Replay, which is also passed to HTTP.Requests.
+// Requests whose body was truncated are refused.
+func (c *Client) Replay(ctx context.Context, r Request) (Request, error) {
+	if r.BodyTruncated {
+		return Request{}, errors.New("request body too large to replay")
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, "http://"+c.LocalAddr+r.URI, bytes.NewReader(r.Body))
+	if err != nil {
+		return Request{}, err
+	}
+	req.Header = r.Header.Clone()
+	req.Host = r.Host
+	out := r
+	out.Time, out.Replay, out.Status = time.Now(), true, 0
+
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true, DisableCompression: true},
+		// Show redirects as the app sent them
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		out.Duration = time.Since(out.Time)
+		return out, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	out.Status, out.Duration = resp.StatusCode, time.Since(out.Time)
+	if o := c.HTTP; o != nil && o.Requests != nil {
+		o.Requests(out)
+	}
+	return out, nil
+}
+
+// readFinalResponse reads the response to req, relaying any informational
+// (1xx) responses other than 101 straight to the visitor.
+func readFinalResponse(lr *bufio.Reader, req *http.Request, remote net.Conn) (*http.Response, error) {
+	for {
+		resp, err := http.ReadResponse(lr, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+		if err := resp.Write(remote); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pipeBuffered copies in both directions, draining any bytes already buffered
+// by the HTTP readers before falling through to the underlying connections.
+func pipeBuffered(a net.Conn, ar *bufio.Reader, b net.Conn, br *bufio.Reader) {
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(b, ar); b.Close(); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(a, br); a.Close(); done <- struct{}{} }()
+	<-done
+	<-done
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// proxied is a visitor and a local app connected through HTTPOptions.forward.
+type proxied struct {
+	visitor net.Conn
+	vr      *bufio.Reader
+	app     net.Conn
+	ar      *bufio.Reader
+}
+
+func startForward(t *testing.T, o *HTTPOptions) *proxied {
+	t.Helper()
+	visitor, remote := net.Pipe()
+	local, app := net.Pipe()
+	deadline := time.Now().Add(5 * time.Second)
+	_ = visitor.SetDeadline(deadline)
+	_ = app.SetDeadline(deadline)
+	t.Cleanup(func() {
+		visitor.Close()
+		app.Close()
+	})
+	go o.forward(remote, local, "localhost:3000")
+	return &proxied{visitor: visitor, vr: bufio.NewReader(visitor), app: app, ar: bufio.NewReader(app)}
+}
+
+// send writes raw bytes from the visitor without waiting for them to be read.
+func (p *proxied) send(raw string) {
+	go func() { _, _ = io.WriteString(p.visitor, raw) }()
+}
+
+// request returns the request as the local app received it.
+func (p *proxied) request(t *testing.T) *http.Request {
+	t.Helper()
+	req, err := http.ReadRequest(p.ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// response returns the next response as the visitor received it.
+func (p *proxied) response(t *testing.T) *http.Response {
+	t.Helper()
+	resp, err := http.ReadResponse(p.vr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestForwardHost(t *testing.T) {
+	for name, tc := range map[string]struct {
+		hostHeader    string
+		host, fwdHost string
+	}{
+		"keep":     {hostHeader: "", host: "abc.example.com"},
+		"rewrite":  {hostHeader: "rewrite", host: "localhost:3000", fwdHost: "abc.example.com"},
+		"verbatim": {hostHeader: "api.internal", host: "api.internal", fwdHost: "abc.example.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := startForward(t, &HTTPOptions{HostHeader: tc.hostHeader})
+			p.send("GET / HTTP/1.1\r\nHost: abc.example.com\r\n\r\n")
+			req := p.request(t)
+			if req.Host != tc.host || req.Header.Get("X-Forwarded-Host") != tc.fwdHost {
+				t.Fatalf("app got Host %q, X-Forwarded-Host %q; want %q, %q",
+					req.Host, req.Header.Get("X-Forwarded-Host"), tc.host, tc.fwdHost)
+			}
+			if _, ok := req.Header["User-Agent"]; ok {
+				t.Fatalf("User-Agent %q added to a request without one", req.Header.Get("User-Agent"))
+			}
+		})
+	}
+}
+
+func TestForwardHeaderRules(t *testing.T) {
+	p := startForward(t, &HTTPOptions{
+		RequestHeaders: HeaderRules{
+			Add:    http.Header{"X-Env": {"staging"}, "Accept": {"application/json"}},
+			Remove: []string{"Cookie", "Accept"},
+		},
+		ResponseHeaders: HeaderRules{
+			Add:    http.Header{"X-Frame-Options": {"DENY"}},
+			Remove: []string{"Server"},
+		},
+	})
+	p.send("GET /a HTTP/1.1\r\nHost: abc.example.com\r\nCookie: id=1\r\nAccept: text/html\r\nX-Env: prod\r\n\r\n")
+	req := p.request(t)
+	if got := req.Header.Values("X-Env"); len(got) != 1 || got[0] != "staging" {
+		t.Fatalf("X-Env = %q, want the added value only", got)
+	}
+	// Removals run first, so a header listed in both is replaced.
+	if req.Header.Get("Cookie") != "" || req.Header.Get("Accept") != "application/json" {
+		t.Fatalf("app got headers %v", req.Header)
+	}
+
+	go func() {
+		_, _ = io.WriteString(p.app, "HTTP/1.1 200 OK\r\nServer: secret/1.0\r\nContent-Length: 2\r\n\r\nok")
+	}()
+	resp := p.response(t)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Server") != "" || resp.Header.Get("X-Frame-Options") != "DENY" || string(body) != "ok" {
+		t.Fatalf("visitor got %v %q", resp.Header, body)
+	}
+
+	// The connection stays open for the next request.
+	p.send("GET /b HTTP/1.1\r\nHost: abc.example.com\r\n\r\n")
+	if req := p.request(t); req.URL.Path != "/b" {
+		t.Fatalf("second request for %q", req.URL.Path)
+	}
+}
+
+func TestForwardInformational(t *testing.T) {
+	p := startForward(t, &HTTPOptions{})
+	p.send("POST /upload HTTP/1.1\r\nHost: abc.example.com\r\nExpect: 100-continue\r\nContent-Length: 0\r\n\r\n")
+	p.request(t)
+	go func() {
+		_, _ = io.WriteString(p.app, "HTTP/1.1 103 Early Hints\r\nLink: </app.css>; rel=preload\r\n\r\n"+
+			"HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n")
+	}()
+	hints := p.response(t)
+	if hints.StatusCode != http.StatusEarlyHints || hints.Header.Get("Link") == "" {
+		t.Fatalf("first response = %d %v, want the early hints", hints.StatusCode, hints.Header)
+	}
+	if resp := p.response(t); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("final response = %d, want 201", resp.StatusCode)
+	}
+}
+
+func TestForwardUpgrade(t *testing.T) {
+	p := startForward(t, &HTTPOptions{ResponseHeaders: HeaderRules{Add: http.Header{"X-Via": {"got"}}}})
+	p.send("GET /ws HTTP/1.1\r\nHost: abc.example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	if req := p.request(t); req.Header.Get("Upgrade") != "websocket" {
+		t.Fatalf("app got Upgrade %q", req.Header.Get("Upgrade"))
+	}
+	// Bytes the app sends right after the 101 belong to the new protocol.
+	go func() {
+		_, _ = io.WriteString(p.app, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\nhello")
+	}()
+	resp := p.response(t)
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("X-Via") != "got" {
+		t.Fatalf("visitor got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	read := func(r io.Reader, n int) string {
+		t.Helper()
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := read(p.vr, 5); got != "hello" {
+		t.Fatalf("visitor read %q after the upgrade", got)
+	}
+	p.send("ping")
+	if got := read(p.ar, 4); got != "ping" {
+		t.Fatalf("app read %q after the upgrade", got)
+	}
+	go func() { _, _ = io.WriteString(p.app, "pong") }()
+	if got := read(p.vr, 4); got != "pong" {
+		t.Fatalf("visitor read %q", got)
+	}
+}
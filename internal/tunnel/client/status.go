@@ -0,0 +1,214 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/protocol/control"
+)
+
+// Tunnel states reported in Status
+const (
+	StateConnecting = "connecting"
+	StateOnline     = "online"
+	StateClosed     = "closed"
+)
+
+// Status is a snapshot of a client's tunnel, as served by the local API
+type Status struct {
+	Name        string    `json:"name"`
+	LocalAddr   string    `json:"local_addr"`
+	URL         string    `json:"url,omitempty"`
+	TunnelID    string    `json:"tunnel_id,omitempty"`
+	State       string    `json:"state"`
+	StartedAt   time.Time `json:"started_at"`
+	ActiveConns int64     `json:"active_conns"`
+	TotalConns  int64     `json:"total_conns"`
+	BytesIn     int64     `json:"bytes_in"`  // visitor -> local
+	BytesOut    int64     `json:"bytes_out"` // local -> visitor
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+
+	// Latest server health check, see control.HealthReport
+	Health          string    `json:"health,omitempty"` // "healthy" or "unhealthy"
+	HealthError     string    `json:"health_error,omitempty"`
+	HealthCheckedAt time.Time `json:"health_checked_at,omitzero"`
+
+	// Upstream is whether the local app accepts connections: "up" or "down"
+	Upstream string `json:"upstream,omitempty"`
+
+	// Traffic is the server's latest count of visitors, if it sends one
+	Traffic *Traffic `json:"traffic,omitempty"`
+
+	// LatencyMS is the last round trip to the server, when Client.PingInterval is set
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+
+	Paused bool `json:"paused,omitempty"`
+}
+
+// Traffic is the server's view of a tunnel's visitors, from the
+// tunnel_stats messages it pushes. Counts are since the tunnel opened.
+type Traffic struct {
+	ActiveConns   int64     `json:"active_conns"`
+	TotalConns    int64     `json:"total_conns"`
+	Requests      int64     `json:"requests"` // only counted when the server proxies HTTP itself
+	BytesIn       int64     `json:"bytes_in"` // from visitors
+	BytesOut      int64     `json:"bytes_out"`
+	Errors        int64     `json:"errors"`
+	LastVisitorIP string    `json:"last_visitor_ip,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Health states reported in Status
+const (
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// Upstream states reported in Status
+const (
+	UpstreamUp   = "up"
+	UpstreamDown = "down"
+)
+
+// stats holds the live counters behind Status
+type stats struct {
+	activeConns atomic.Int64
+	totalConns  atomic.Int64
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+
+	mu          sync.Mutex
+	state       string
+	url         string
+	tunnelID    string
+	startedAt   time.Time
+	lastError   string
+	lastErrorAt time.Time
+
+	health          string
+	healthError     string
+	healthCheckedAt time.Time
+
+	upstream string
+	traffic  *Traffic
+	latency  time.Duration
+}
+
+func (s *stats) setState(state, url, tunnelID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.startedAt.IsZero() {
+		s.startedAt = time.Now()
+	}
+	s.state, s.url, s.tunnelID = state, url, tunnelID
+	s.traffic, s.latency = nil, 0 // measured again for every tunnel
+}
+
+func (s *stats) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	s.lastErrorAt = time.Now()
+}
+
+// setHealth records a health report and returns the previous health state.
+func (s *stats) setHealth(r control.HealthReport) (prev string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev = s.health
+	s.health, s.healthError = HealthHealthy, ""
+	if !r.OK {
+		s.health, s.healthError = HealthUnhealthy, r.Error
+	}
+	s.healthCheckedAt = time.Now()
+	return prev
+}
+
+// setUpstream records the local app's state, UpstreamUp, UpstreamDown or ""
+// for unknown, and returns the previous one.
+func (s *stats) setUpstream(state string) (prev string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, s.upstream = s.upstream, state
+	return prev
+}
+
+func (s *stats) setTraffic(t *Traffic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.traffic = t
+}
+
+func (s *stats) setLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// name returns the label used for the tunnel in the local API.
+func (c *Client) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.LocalAddr
+}
+
+// Status returns a snapshot of the tunnel's state and counters.
+func (c *Client) Status() Status {
+	s := &c.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state
+	if state == "" {
+		state = StateConnecting
+	}
+	return Status{
+		Name:        c.name(),
+		LocalAddr:   c.LocalAddr,
+		URL:         s.url,
+		TunnelID:    s.tunnelID,
+		State:       state,
+		StartedAt:   s.startedAt,
+		ActiveConns: s.activeConns.Load(),
+		TotalConns:  s.totalConns.Load(),
+		BytesIn:     s.bytesIn.Load(),
+		BytesOut:    s.bytesOut.Load(),
+		LastError:   s.lastError,
+		LastErrorAt: s.lastErrorAt,
+
+		Health:          s.health,
+		HealthError:     s.healthError,
+		HealthCheckedAt: s.healthCheckedAt,
+
+		Upstream: s.upstream,
+		Traffic:  s.traffic,
+
+		LatencyMS: float64(s.latency.Microseconds()) / 1000,
+		Paused:    c.Paused(),
+	}
+}
+
+// countingConn tallies bytes read from and written to a data connection,
+// both for the connection itself and into the client-wide totals.
+type countingConn struct {
+	net.Conn
+	in, out   *atomic.Int64
+	nIn, nOut atomic.Int64 // this connection only
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.Add(int64(n))
+	c.nIn.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.Add(int64(n))
+	c.nOut.Add(int64(n))
+	return n, err
+}
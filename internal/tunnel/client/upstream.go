@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/offline"
+	"github.com/HeyRistaa/got/internal/protocol/control"
+)
+
+// Local app probing
+const (
+	upstreamProbeInterval = 5 * time.Second
+	upstreamProbeTimeout  = 2 * time.Second
+
+	// offlineReadTimeout bounds how long a visitor has to send its request
+	// before a connection to a down app is closed without an offline page.
+	offlineReadTimeout = 5 * time.Second
+)
+
+// watchUpstream dials LocalAddr every upstreamProbeInterval until the
+// session ends, reporting when the local app stops or starts accepting
+// connections.
+func (c *Client) watchUpstream(sess *Session) {
+	c.stats.setUpstream("")
+	ticker := time.NewTicker(upstreamProbeInterval)
+	defer ticker.Stop()
+	for {
+		conn, err := net.DialTimeout("tcp", c.LocalAddr, upstreamProbeTimeout)
+		if err == nil {
+			conn.Close()
+		}
+		c.upstreamState(sess, err)
+		select {
+		case <-sess.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// upstreamState records the outcome of a dial to the local app. On a change
+// it tells the server, so it can answer visitors itself while the app is
+// down, and reports the change.
+func (c *Client) upstreamState(sess *Session, dialErr error) {
+	state := UpstreamUp
+	if dialErr != nil {
+		state = UpstreamDown
+	}
+	prev := c.stats.setUpstream(state)
+	if prev == state || (prev == "" && state == UpstreamUp) {
+		return
+	}
+	msg := control.UpstreamStatus{Type: "upstream_status", TunnelID: sess.Opened.TunnelID, Up: dialErr == nil}
+	if dialErr != nil {
+		msg.Error = dialErr.Error()
+	}
+	_ = sess.send(msg)
+
+	up := dialErr == nil
+	c.emit(Event{Type: EventUpstream, TunnelID: sess.Opened.TunnelID, Up: &up, Error: msg.Error})
+}
+
+// serveOffline answers an HTTP visitor whose local app could not be dialed
+// with the offline page or, when paused holds a pause message, with the
+// paused page. The server answers visitors of a paused tunnel itself; this
+// covers servers that predate pause_tunnel. Callers use it only when
+// Client.HTTP is set; raw TCP connections are closed straight away.
+func serveOffline(conn net.Conn, paused *string) {
+	_ = conn.SetReadDeadline(time.Now().Add(offlineReadTimeout))
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	req.Body.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(offlineReadTimeout))
+	if paused != nil {
+		_ = offline.WritePaused(conn, req.Host, *paused)
+		return
+	}
+	_ = offline.WriteResponse(conn, req.Host)
+}
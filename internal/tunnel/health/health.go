@@ -1,47 +1,215 @@
 package health
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/HeyRistaa/got/internal/logging"
+)
+
+// Health check actions
+const (
+	ActionWarn  = "warn"  // report failures to the client only
+	ActionClose = "close" // close the tunnel once the failure threshold is reached
 )
 
+// Policy describes how a tunnel is probed and what happens when it fails
+type Policy struct {
+	Path      string        // request path, e.g. "/healthz"
+	Expect    StatusSet     // statuses counted as healthy; empty means anything below 500
+	Interval  time.Duration // time between probes
+	Timeout   time.Duration // per-probe timeout
+	Threshold int           // consecutive failures before Action applies
+	Action    string        // ActionWarn or ActionClose
+}
+
+// DefaultPolicy probes "/" every 2 minutes and only warns, so an app that
+// legitimately returns errors never loses its tunnel.
+func DefaultPolicy() Policy {
+	return Policy{
+		Path:      "/",
+		Interval:  2 * time.Minute,
+		Timeout:   10 * time.Second,
+		Threshold: 3,
+		Action:    ActionWarn,
+	}
+}
+
+// StatusSet is a set of HTTP status ranges
+type StatusSet []StatusRange
+
+// StatusRange is an inclusive range of HTTP statuses
+type StatusRange struct{ Min, Max int }
+
+// ParseStatusSet parses a comma-separated list such as "200-299,404" or
+// "2xx,3xx".
+func ParseStatusSet(s string) (StatusSet, error) {
+	var set StatusSet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		var r StatusRange
+		var err error
+		switch {
+		case len(part) == 3 && strings.HasSuffix(part, "xx"):
+			var class int
+			class, err = strconv.Atoi(part[:1])
+			r = StatusRange{class * 100, class*100 + 99}
+		case strings.Contains(part, "-"):
+			lo, hi, _ := strings.Cut(part, "-")
+			r.Min, err = strconv.Atoi(lo)
+			if err == nil {
+				r.Max, err = strconv.Atoi(hi)
+			}
+		default:
+			r.Min, err = strconv.Atoi(part)
+			r.Max = r.Min
+		}
+		if err != nil || r.Min < 100 || r.Max > 599 || r.Min > r.Max {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		set = append(set, r)
+	}
+	return set, nil
+}
+
+// Contains reports whether status is in the set. An empty set holds every
+// status below 500.
+func (s StatusSet) Contains(status int) bool {
+	if len(s) == 0 {
+		return status < 500
+	}
+	for _, r := range s {
+		if status >= r.Min && status <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func (s StatusSet) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		if r.Min == r.Max {
+			parts[i] = strconv.Itoa(r.Min)
+		} else {
+			parts[i] = fmt.Sprintf("%d-%d", r.Min, r.Max)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// DialFunc opens a connection to the app behind a tunnel
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// Result is the outcome of one probe
+type Result struct {
+	Time     time.Time
+	OK       bool
+	Status   int // 0 when no response was received
+	Error    string
+	Latency  time.Duration
+	Failures int // consecutive failures including this one
+}
+
 // Checker handles health checking for tunnel endpoints
 type Checker struct {
-	client *http.Client
+	userAgent string
+
+	// Logger receives probe results at debug level; nil logs to
+	// slog.Default().
+	Logger *slog.Logger
+
+	// Skip, if set, reports whether the tunnel is not serving visitors on
+	// purpose, e.g. while paused. Run skips probes while it returns true,
+	// and starts counting failures afresh once it returns false.
+	Skip func() bool
 }
 
 // New creates a new health checker
 func New() *Checker {
-	return &Checker{
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-	}
+	return &Checker{userAgent: "got-health-check"}
 }
 
-// CheckTunnelEndpoint pings a tunnel endpoint to see if it's healthy
-func (c *Checker) CheckTunnelEndpoint(host string) error {
-	// Ping the tunnel endpoint via HTTPS
-	url := "https://" + host
+// Probe sends one GET for p.Path to host over a connection from dial, so the
+// request goes through the tunnel itself rather than public DNS.
+func (c *Checker) Probe(ctx context.Context, dial DialFunc, host string, p Policy) Result {
+	start := time.Now()
+	res := Result{Time: start}
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	transport := &http.Transport{
+		DialContext:       func(ctx context.Context, _, _ string) (net.Conn, error) { return dial(ctx) },
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
 
-	resp, err := c.client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+p.Path, nil)
 	if err != nil {
-		// Don't fail on timeout - just log and continue
-		if strings.Contains(err.Error(), "timeout") {
-			fmt.Printf("Health check timeout for %s (this is normal if client is idle)\n", host)
-			return nil // Don't fail on timeout
-		}
-		return fmt.Errorf("failed to reach tunnel endpoint: %w", err)
+		res.Error = err.Error()
+		return res
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	resp, err := transport.RoundTrip(req)
+	res.Latency = time.Since(start)
+	if err != nil {
+		res.Error = err.Error()
+		return res
 	}
-	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
 
-	// Consider 2xx, 3xx, and 4xx as "healthy" (tunnel is working)
-	// Only 5xx and connection errors are "unhealthy"
-	if resp.StatusCode >= 500 {
-		return fmt.Errorf("tunnel endpoint returned %d", resp.StatusCode)
+	res.Status = resp.StatusCode
+	res.OK = p.Expect.Contains(resp.StatusCode)
+	if !res.OK {
+		res.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
 	}
+	return res
+}
+
+// Run probes every p.Interval until ctx is done, passing each result to
+// report. With ActionClose it returns true once p.Threshold consecutive
+// probes have failed. No probes are sent while c.Skip reports true.
+func (c *Checker) Run(ctx context.Context, dial DialFunc, host string, p Policy, report func(Result)) (unhealthy bool) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
 
-	return nil
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		if c.Skip != nil && c.Skip() {
+			failures = 0
+			continue
+		}
+		res := c.Probe(ctx, dial, host, p)
+		if ctx.Err() != nil {
+			return false
+		}
+		if res.OK {
+			failures = 0
+		} else {
+			failures++
+		}
+		res.Failures = failures
+		logging.Or(c.Logger).Debug("health probe", "host", host, "ok", res.OK, "status", res.Status,
+			"latency", res.Latency, "failures", failures, "error", res.Error)
+		report(res)
+		if p.Action == ActionClose && failures >= p.Threshold {
+			return true
+		}
+	}
 }
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+func failingDial(calls *int) DialFunc {
+	return func(context.Context) (net.Conn, error) {
+		*calls++
+		return nil, errDown
+	}
+}
+
+func testPolicy(action string) Policy {
+	return Policy{Path: "/", Interval: time.Millisecond, Timeout: time.Second, Threshold: 2, Action: action}
+}
+
+func TestRunClosesAtThreshold(t *testing.T) {
+	var calls int
+	var failures []int
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	unhealthy := New().Run(ctx, failingDial(&calls), "app.test", testPolicy(ActionClose), func(r Result) {
+		failures = append(failures, r.Failures)
+	})
+	if !unhealthy || !slices.Equal(failures, []int{1, 2}) {
+		t.Fatalf("Run = %v with failures %v, want unhealthy after [1 2]", unhealthy, failures)
+	}
+}
+
+func TestRunSkipsWhilePaused(t *testing.T) {
+	// Probe, probe, pause for two ticks, probe: the failures after the
+	// pause count from one again and never reach the threshold.
+	var calls, ticks int
+	var failures []int
+	c := New()
+	c.Skip = func() bool {
+		ticks++
+		return ticks == 2 || ticks == 3
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	unhealthy := c.Run(ctx, failingDial(&calls), "app.test", testPolicy(ActionClose), func(r Result) {
+		failures = append(failures, r.Failures)
+		if len(failures) == 2 {
+			cancel()
+		}
+	})
+	if unhealthy || calls != 2 || !slices.Equal(failures, []int{1, 1}) {
+		t.Fatalf("Run = %v with %d probes and failures %v, want 2 probes failing [1 1]", unhealthy, calls, failures)
+	}
+}
+
+func TestParseStatusSet(t *testing.T) {
+	set, err := ParseStatusSet("2xx, 404,500-503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := set.String(); got != "200-299,404,500-503" {
+		t.Fatalf("String = %q", got)
+	}
+	for status, want := range map[int]bool{200: true, 299: true, 301: false, 404: true, 502: true, 504: false} {
+		if set.Contains(status) != want {
+			t.Errorf("Contains(%d) = %v, want %v", status, !want, want)
+		}
+	}
+	if !(StatusSet{}).Contains(404) || (StatusSet{}).Contains(500) {
+		t.Error("empty set should hold every status below 500")
+	}
+	for _, bad := range []string{"6xx", "200-100", "abc", "99"} {
+		if _, err := ParseStatusSet(bad); err == nil {
+			t.Errorf("ParseStatusSet(%q) succeeded", bad)
+		}
+	}
+}
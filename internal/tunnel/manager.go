@@ -1,25 +1,41 @@
 package tunnel
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
+	"github.com/HeyRistaa/got/internal/logging"
 	"github.com/HeyRistaa/got/internal/proxy/caddy"
 	"github.com/HeyRistaa/got/internal/tunnel/health"
 )
 
+// RouteProvider publishes and removes the public host -> local port routes
+// for tunnels. *caddy.Client is the production implementation.
+type RouteProvider interface {
+	AddRoute(host string, port int) error
+	DeleteRouteByHost(host string) error
+}
+
 // Manager handles tunnel lifecycle
 type Manager struct {
-	caddyClient   *caddy.Client
+	routes        RouteProvider
 	healthChecker *health.Checker
 	mu            sync.Mutex // protects tunnel creation
+
+	// DisableHealthCheck turns off endpoint polling regardless of
+	// GOT_DISABLE_HEALTH_CHECK, e.g. for in-process tests.
+	DisableHealthCheck bool
+
+	// Logger receives tunnel lifecycle records; nil logs to slog.Default().
+	Logger *slog.Logger
 }
 
 // Tunnel represents a single tunnel
@@ -32,10 +48,15 @@ type Tunnel struct {
 	Listener net.Listener
 }
 
-// NewManager creates a new tunnel manager
+// NewManager creates a new tunnel manager routing through the local Caddy admin API
 func NewManager() *Manager {
+	return NewManagerWithRoutes(caddy.New("http://127.0.0.1:2019"))
+}
+
+// NewManagerWithRoutes creates a tunnel manager using the given route provider
+func NewManagerWithRoutes(routes RouteProvider) *Manager {
 	return &Manager{
-		caddyClient:   caddy.New("http://127.0.0.1:2019"),
+		routes:        routes,
 		healthChecker: health.New(),
 	}
 }
@@ -52,7 +73,8 @@ func (m *Manager) CreateTunnel(clientID, domain string) (*Tunnel, error) {
 	}
 	host := fmt.Sprintf("%s.%s", label, domain)
 
-	fmt.Printf("Creating tunnel for client %s: %s\n", clientID, host)
+	log := logging.Or(m.Logger).With(logging.ClientID, clientID, "host", host)
+	log.Debug("creating tunnel")
 
 	// Allocate port
 	port, listener, err := m.allocatePort()
@@ -60,15 +82,15 @@ func (m *Manager) CreateTunnel(clientID, domain string) (*Tunnel, error) {
 		return nil, fmt.Errorf("failed to allocate port: %w", err)
 	}
 
-	fmt.Printf("Allocated port %d for %s\n", port, host)
+	log.Debug("allocated port", "port", port)
 
 	// Create Caddy route
-	if err := m.caddyClient.AddRoute(host, port); err != nil {
+	if err := m.routes.AddRoute(host, port); err != nil {
 		listener.Close()
 		return nil, fmt.Errorf("failed to add caddy route: %w", err)
 	}
 
-	fmt.Printf("Added Caddy route for %s -> port %d\n", host, port)
+	log.Debug("added route", "port", port)
 
 	tunnel := &Tunnel{
 		ID:       randomID(),
@@ -82,28 +104,25 @@ func (m *Manager) CreateTunnel(clientID, domain string) (*Tunnel, error) {
 	return tunnel, nil
 }
 
-// StartHealthCheck starts health checking for a tunnel
-func (m *Manager) StartHealthCheck(tunnel *Tunnel, cleanupFunc func()) {
+// StartHealthCheck probes the tunnel with policy p through dial until ctx is
+// done, skipping probes while skip reports true. Every result goes to
+// report; cleanupFunc runs if the policy decides the tunnel should be closed.
+func (m *Manager) StartHealthCheck(ctx context.Context, tunnel *Tunnel, p health.Policy, dial health.DialFunc, skip func() bool, report func(health.Result), cleanupFunc func()) {
+	log := logging.Or(m.Logger).With(logging.TunnelID, tunnel.ID)
+
 	// Skip health check if GOT_DISABLE_HEALTH_CHECK is set
-	if os.Getenv("GOT_DISABLE_HEALTH_CHECK") != "" {
-		fmt.Printf("Health checks disabled for tunnel %s\n", tunnel.Host)
+	if m.DisableHealthCheck || os.Getenv("GOT_DISABLE_HEALTH_CHECK") != "" {
+		log.Debug("health checks disabled", "host", tunnel.Host)
 		return
 	}
 
+	checker := *m.healthChecker
+	checker.Logger = log
+	checker.Skip = skip
 	go func() {
-		ticker := time.NewTicker(2 * time.Minute) // Check every 2 minutes
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				if err := m.healthChecker.CheckTunnelEndpoint(tunnel.Host); err != nil {
-					fmt.Printf("Tunnel endpoint health check failed for %s: %v\n", tunnel.Host, err)
-					cleanupFunc()
-					return
-				}
-				fmt.Printf("Tunnel endpoint health check passed for %s\n", tunnel.Host)
-			}
+		if checker.Run(ctx, dial, tunnel.Host, p, report) {
+			log.Warn("health checks failing, closing tunnel", "host", tunnel.Host, "failures", p.Threshold)
+			cleanupFunc()
 		}
 	}()
 }
@@ -115,7 +134,7 @@ func (m *Manager) CloseTunnel(tunnel *Tunnel) error {
 	}
 
 	// Remove Caddy route
-	if err := m.caddyClient.DeleteRouteByHost(tunnel.Host); err != nil {
+	if err := m.routes.DeleteRouteByHost(tunnel.Host); err != nil {
 		return fmt.Errorf("failed to delete caddy route: %w", err)
 	}
 
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/logging"
+)
+
+// AbuseRules configures automatic bans. Each signal is counted per IP over
+// Window; reaching its threshold bans the IP for BanDuration. Zero
+// thresholds disable a signal. Trusted proxies are never banned.
+type AbuseRules struct {
+	Window      time.Duration
+	BanDuration time.Duration
+
+	RateLimitViolations int // control connections or tunnels refused by RateLimits
+	FailedAuth          int // visitor requests with wrong Basic auth credentials, and bad admin API tokens
+
+	// ShortLivedTunnels is the number of tunnels closed within ShortLived
+	// of opening that gets their client banned.
+	ShortLivedTunnels int
+	ShortLived        time.Duration
+
+	// PhishingPaths are path.Match patterns (e.g. "/wp-admin/*",
+	// "/*/signin/v2/identifier") that a tunnel should not answer with 2xx.
+	// When one does, the tunnel is flagged for an operator to review: it is
+	// logged, audited and sent as an abuse.flagged webhook. Catch-all apps
+	// answer such paths too and anyone can request them, so nothing is
+	// closed or banned automatically. Only tunnels served through the HTTP
+	// edge are checked; raw TCP tunnels are left alone.
+	PhishingPaths []string
+}
+
+// DefaultAbuseRules bans IPs for an hour after sustained rate-limit
+// violations, auth failures or churning tunnels.
+func DefaultAbuseRules() AbuseRules {
+	return AbuseRules{
+		Window:              10 * time.Minute,
+		BanDuration:         time.Hour,
+		RateLimitViolations: 50,
+		FailedAuth:          100,
+		ShortLivedTunnels:   30,
+		ShortLived:          10 * time.Second,
+	}
+}
+
+// Abuse signals counted by the detector
+const (
+	signalRateLimit  = "rate_limit"
+	signalFailedAuth = "failed_auth"
+	signalShortLived = "short_lived_tunnel"
+)
+
+// abuseDetector counts signals per IP in a sliding window. Rules are read
+// from the server on each use.
+type abuseDetector struct {
+	s *Server
+
+	mu     sync.Mutex
+	events map[string][]time.Time // "<signal>|<ip>" -> recent occurrences
+}
+
+func newAbuseDetector(s *Server) *abuseDetector {
+	return &abuseDetector{s: s, events: make(map[string][]time.Time)}
+}
+
+func (a *abuseDetector) threshold(signal string) int {
+	switch signal {
+	case signalRateLimit:
+		return a.s.Abuse.RateLimitViolations
+	case signalFailedAuth:
+		return a.s.Abuse.FailedAuth
+	case signalShortLived:
+		return a.s.Abuse.ShortLivedTunnels
+	}
+	return 0
+}
+
+// record counts one occurrence of signal for ip and bans the IP once the
+// threshold is reached within the window. IPv6 addresses are counted and
+// banned per /64, like the rate limits, since one host usually holds the
+// whole range.
+func (a *abuseDetector) record(signal string, ip netip.Addr) {
+	limit := a.threshold(signal)
+	if limit <= 0 || !ip.IsValid() || containsAddr(a.s.TrustedProxies, ip) {
+		return
+	}
+	key := signal + "|" + limiterKey(ip)
+	now := time.Now()
+
+	a.mu.Lock()
+	if len(a.events) >= maxLimiterKeys {
+		a.sweepLocked(now)
+	}
+	times := a.events[key]
+	for len(times) > 0 && now.Sub(times[0]) > a.s.Abuse.Window {
+		times = times[1:]
+	}
+	times = append(times, now)
+	hit := len(times) >= limit
+	if hit {
+		delete(a.events, key)
+	} else {
+		a.events[key] = times
+	}
+	a.mu.Unlock()
+
+	if hit {
+		b := Ban{Kind: BanIP, Value: ip.String(), Reason: signal + " threshold reached"}
+		if ip.Is6() {
+			b.Kind, b.Value = BanCIDR, limiterKey(ip)
+		}
+		a.ban(b)
+	}
+}
+
+// sweepLocked drops signals with no occurrence inside the window, and
+// everything if that is not enough to stay under the key cap.
+func (a *abuseDetector) sweepLocked(now time.Time) {
+	for key, times := range a.events {
+		if now.Sub(times[len(times)-1]) > a.s.Abuse.Window {
+			delete(a.events, key)
+		}
+	}
+	if len(a.events) >= maxLimiterKeys {
+		clear(a.events)
+	}
+}
+
+func (a *abuseDetector) ban(b Ban) {
+	b.Auto = true
+	if a.s.Abuse.BanDuration > 0 {
+		b.Expires = time.Now().Add(a.s.Abuse.BanDuration).UTC()
+	}
+	added, err := a.s.Bans.Add(b)
+	if err != nil {
+		a.s.logger().Error("abuse: add ban", "kind", b.Kind, "value", b.Value, logging.Err(err))
+		return
+	}
+	a.s.logger().Warn("abuse: banned", "kind", added.Kind, "value", added.Value, "reason", added.Reason)
+	a.s.dropBanned(added)
+}
+
+// phishingPath reports whether p matches one of the phishing patterns.
+func (a *abuseDetector) phishingPath(p string) bool {
+	for _, pattern := range a.s.Abuse.PhishingPaths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		// A trailing "/*" also covers deeper paths
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// phishingGuard flags the tunnel when it answers a phishing path with a
+// 2xx response.
+func (s *Server) phishingGuard(tunnelID string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.abuse.phishingPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status >= 200 && sw.status < 300 {
+			s.reportPhishing(tunnelID, s.visitorIP(r), r.URL.Path)
+		}
+	})
+}
+
+// reportPhishing counts a phishing path served by the tunnel. The first one
+// flags the tunnel and reports it; the rest only add to its count.
+func (s *Server) reportPhishing(tunnelID string, visitor netip.Addr, urlPath string) {
+	s.mu.RLock()
+	t := s.tunnels[tunnelID]
+	s.mu.RUnlock()
+	if t == nil {
+		return
+	}
+	t.abuseReports.Add(1)
+	reason := "served phishing path " + urlPath
+	if !t.flagged.CompareAndSwap(nil, &reason) {
+		return
+	}
+	t.log.Warn("abuse: tunnel flagged for review", "reason", reason, logging.RemoteIP, visitor.String())
+	s.Audit.Record(AuditEvent{
+		Type:     AuditAbuseReport,
+		TunnelID: t.tunnel.ID,
+		Host:     t.tunnel.Host,
+		ClientID: t.clientID,
+		ClientIP: t.clientIP,
+		Token:    tokenKey(t.token),
+		RemoteIP: visitor.String(),
+		Reason:   reason,
+	})
+	s.notifyTunnel(t, WebhookAbuseFlagged, "", reason)
+}
+
+// statusWriter records the status code and body size written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// banHandler turns away visitors whose IP is banned.
+func (s *Server) banHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Bans.matchIP(s.visitorIP(r)) != nil {
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/tunnel"
+)
+
+func TestAbuseBansIPv6Prefix(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	s.Abuse = AbuseRules{Window: time.Minute, FailedAuth: 3}
+	ctl, peer := net.Pipe()
+	defer peer.Close()
+	s.tunnels["t1"] = &tunnelInfo{tunnel: &tunnel.Tunnel{ID: "t1"}, ctlConn: ctl, clientIP: "2001:db8::99"}
+
+	// Rotating addresses inside one /64 still adds up.
+	for _, ip := range []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"} {
+		s.abuse.record(signalFailedAuth, netip.MustParseAddr(ip))
+	}
+	bans := s.Bans.List()
+	if len(bans) != 1 || bans[0].Kind != BanCIDR || bans[0].Value != "2001:db8::/64" || !bans[0].Auto {
+		t.Fatalf("bans = %+v, want an automatic 2001:db8::/64 ban", bans)
+	}
+	if s.tunnels["t1"].reason() != CloseBanned {
+		t.Fatal("tunnel of the banned prefix left open")
+	}
+}
+
+func TestAbuseBansIPv4Address(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	s.Abuse = AbuseRules{Window: time.Minute, FailedAuth: 2}
+	s.abuse.record(signalFailedAuth, netip.MustParseAddr("192.0.2.1"))
+	s.abuse.record(signalFailedAuth, netip.MustParseAddr("192.0.2.2"))
+	if bans := s.Bans.List(); len(bans) != 0 {
+		t.Fatalf("bans = %+v, want IPv4 addresses counted apart", bans)
+	}
+	s.abuse.record(signalFailedAuth, netip.MustParseAddr("192.0.2.1"))
+	if bans := s.Bans.List(); len(bans) != 1 || bans[0].Kind != BanIP || bans[0].Value != "192.0.2.1" {
+		t.Fatalf("bans = %+v, want 192.0.2.1 banned", bans)
+	}
+}
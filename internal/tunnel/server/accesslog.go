@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Access log formats
+const (
+	AccessCommon   = "common"   // Common Log Format prefixed with the tunnel host
+	AccessCombined = "combined" // Combined Log Format prefixed with the tunnel host, plus client ID and duration
+	AccessJSON     = "json"     // one JSON object per request
+)
+
+// AccessLog configures the per-request access log. Requests are only visible
+// on the HTTP edge, so tunnels bridged as raw TCP are not logged.
+type AccessLog struct {
+	Writer io.Writer // nil disables the access log, e.g. a logging.RotatingFile
+	Format string    // AccessCommon, AccessCombined or AccessJSON; defaults to AccessCombined
+}
+
+func (a *AccessLog) enabled() bool { return a.Writer != nil }
+
+// accessEntry is one visitor request
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Host       string    `json:"host"`
+	TunnelID   string    `json:"tunnel_id"`
+	ClientID   string    `json:"client_id,omitempty"`
+	RemoteIP   string    `json:"remote_ip"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS int64     `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// format renders e as one line, newline included.
+func (e *accessEntry) format(format string) []byte {
+	if format == AccessJSON {
+		b, _ := json.Marshal(e)
+		return append(b, '\n')
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s - %s [%s] %s %d %s",
+		orDash(e.Host), orDash(e.RemoteIP), orDash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.URI+" "+e.Proto), e.Status, orDash(bytesField(e.Bytes)))
+	if format != AccessCommon {
+		fmt.Fprintf(&b, " %s %s %s %d", strconv.Quote(orDash(e.Referer)), strconv.Quote(orDash(e.UserAgent)),
+			orDash(e.ClientID), e.DurationMS)
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func bytesField(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// accessLog writes one access log line per request to the tunnel, including
+// requests turned away by the handlers it wraps.
+func (s *Server) accessLog(tunnelID string, next http.Handler) http.Handler {
+	var host, clientID string
+	s.mu.RLock()
+	if t := s.tunnels[tunnelID]; t != nil {
+		host, clientID = t.tunnel.Host, t.clientID
+	}
+	s.mu.RUnlock()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		user, _, _ := r.BasicAuth()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		e := accessEntry{
+			Time:       start,
+			Host:       host,
+			TunnelID:   tunnelID,
+			ClientID:   clientID,
+			RemoteIP:   s.visitorIP(r).String(),
+			User:       user,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Status:     sw.status,
+			Bytes:      sw.bytes,
+			DurationMS: time.Since(start).Milliseconds(),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		}
+		switch {
+		case e.Status != 0:
+		case r.Header.Get("Upgrade") != "":
+			// The proxy hijacks upgraded connections and writes the 101 itself
+			e.Status = http.StatusSwitchingProtocols
+		default:
+			e.Status = http.StatusOK
+		}
+		line := e.format(s.AccessLog.Format)
+		s.accessMu.Lock()
+		_, _ = s.AccessLog.Writer.Write(line)
+		s.accessMu.Unlock()
+	})
+}
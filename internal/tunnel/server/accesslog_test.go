@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessEntryFormat(t *testing.T) {
+	e := accessEntry{
+		Time:       time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+		Host:       "abc.example.com",
+		TunnelID:   "t1",
+		ClientID:   "laptop",
+		RemoteIP:   "198.51.100.7",
+		User:       "alice",
+		Method:     http.MethodGet,
+		URI:        "/index.html?q=1",
+		Proto:      "HTTP/1.1",
+		Status:     http.StatusOK,
+		Bytes:      512,
+		DurationMS: 42,
+		Referer:    "https://example.org/",
+		UserAgent:  `curl/8.0 "quoted"`,
+	}
+	common := `abc.example.com 198.51.100.7 - alice [04/Mar/2026:05:06:07 +0000] "GET /index.html?q=1 HTTP/1.1" 200 512`
+	for format, want := range map[string]string{
+		AccessCommon:   common + "\n",
+		AccessCombined: common + ` "https://example.org/" "curl/8.0 \"quoted\"" laptop 42` + "\n",
+		"":             common + ` "https://example.org/" "curl/8.0 \"quoted\"" laptop 42` + "\n",
+	} {
+		if got := string(e.format(format)); got != want {
+			t.Errorf("%q format:\n got %q\nwant %q", format, got, want)
+		}
+	}
+
+	// Empty fields are dashes, and an empty body is "-" rather than 0.
+	empty := accessEntry{Time: e.Time, Method: http.MethodHead, URI: "/", Proto: "HTTP/1.1", Status: http.StatusNoContent}
+	if got, want := string(empty.format(AccessCombined)), `- - - - [04/Mar/2026:05:06:07 +0000] "HEAD / HTTP/1.1" 204 - "-" "-" - 0`+"\n"; got != want {
+		t.Errorf("empty entry:\n got %q\nwant %q", got, want)
+	}
+
+	var decoded accessEntry
+	line := e.format(AccessJSON)
+	if !bytes.HasSuffix(line, []byte("}\n")) {
+		t.Fatalf("JSON line %q is not newline-terminated", line)
+	}
+	if err := json.Unmarshal(line, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != e {
+		t.Fatalf("JSON round trip = %+v, want %+v", decoded, e)
+	}
+}
+
+func TestAccessLogHandler(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	var buf bytes.Buffer
+	s.AccessLog = AccessLog{Writer: &buf, Format: AccessJSON}
+	h := s.accessLog("t1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "nope", http.StatusNotFound)
+		}
+	}))
+
+	for _, path := range []string{"/missing", "/"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Host = "abc.example.com"
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", "198.51.100.7")
+		r.SetBasicAuth("alice", "s3cret")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want 2: %q", len(lines), buf.String())
+	}
+	var missing, ok accessEntry
+	if err := json.Unmarshal([]byte(lines[0]), &missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &ok); err != nil {
+		t.Fatal(err)
+	}
+	// The visitor is the address behind the trusted proxy, and the
+	// password never reaches the log.
+	if missing.Status != http.StatusNotFound || missing.Bytes != int64(len("nope\n")) ||
+		missing.RemoteIP != "198.51.100.7" || missing.User != "alice" || missing.TunnelID != "t1" {
+		t.Fatalf("404 entry = %+v", missing)
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Fatal("access log contains the password")
+	}
+	if ok.Status != http.StatusOK || ok.Bytes != 0 || ok.URI != "/" {
+		t.Fatalf("implicit 200 entry = %+v", ok)
+	}
+}
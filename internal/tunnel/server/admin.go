@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminHandler serves the admin HTTP API, guarded by a bearer token:
+//
+//	GET    /v1/bans                      list bans in force
+//	POST   /v1/bans                      add a ban: {"kind","value","reason","duration"}
+//	DELETE /v1/bans?kind=ip&value=1.2.3.4  lift a ban
+//	GET    /v1/tunnels                   list open tunnels
+//	DELETE /v1/tunnels/{id}              close a tunnel
+//	GET    /v1/stats                     open tunnels and visitors turned away
+//	GET    /v1/audit?type=&tunnel_id=&client_id=&ip=&since=&until=&limit=
+//	                                     query the audit log; times are RFC 3339
+//
+// Requests with a wrong token count as failed auth for the abuse detector.
+// Changes are recorded in the audit log.
+func (s *Server) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/bans", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Bans.List())
+	})
+	mux.HandleFunc("POST /v1/bans", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Kind     string `json:"kind"`
+			Value    string `json:"value"`
+			Reason   string `json:"reason"`
+			Duration string `json:"duration"` // e.g. "24h"; empty means permanent
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		b := Ban{Kind: req.Kind, Value: req.Value, Reason: req.Reason}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid duration")
+				return
+			}
+			b.Expires = time.Now().Add(d).UTC()
+		}
+		b, err := s.Bans.Add(b)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.Audit.Record(AuditEvent{Type: AuditAdmin, RemoteIP: adminIP(r), Action: "ban_add", Target: b.Kind + " " + b.Value, Reason: b.Reason})
+		s.dropBanned(b)
+		writeJSON(w, http.StatusCreated, b)
+	})
+	mux.HandleFunc("DELETE /v1/bans", func(w http.ResponseWriter, r *http.Request) {
+		kind, value := r.URL.Query().Get("kind"), r.URL.Query().Get("value")
+		removed, err := s.Bans.Remove(kind, value)
+		switch {
+		case err != nil:
+			writeError(w, http.StatusBadRequest, err.Error())
+		case !removed:
+			writeError(w, http.StatusNotFound, "no such ban")
+		default:
+			s.Audit.Record(AuditEvent{Type: AuditAdmin, RemoteIP: adminIP(r), Action: "ban_remove", Target: kind + " " + value})
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("GET /v1/tunnels", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.tunnelList())
+	})
+	mux.HandleFunc("DELETE /v1/tunnels/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		s.mu.RLock()
+		t := s.tunnels[id]
+		s.mu.RUnlock()
+		if t == nil {
+			writeError(w, http.StatusNotFound, "no such tunnel")
+			return
+		}
+		s.Audit.Record(AuditEvent{Type: AuditAdmin, RemoteIP: adminIP(r), Action: "tunnel_kill", Target: id, TunnelID: id, Host: t.tunnel.Host, ClientID: t.clientID})
+		t.close(CloseAdminKill)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /v1/stats", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		open := len(s.tunnels)
+		s.mu.RUnlock()
+		writeJSON(w, http.StatusOK, ServerStats{Tunnels: open, Rejected: s.Rejected()})
+	})
+	mux.HandleFunc("GET /v1/audit", func(w http.ResponseWriter, r *http.Request) {
+		if s.Audit == nil {
+			writeError(w, http.StatusNotFound, "audit log not enabled")
+			return
+		}
+		q, err := auditQuery(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		events, err := s.Audit.Query(q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if events == nil {
+			events = []AuditEvent{}
+		}
+		writeJSON(w, http.StatusOK, events)
+	})
+
+	want := sha256.Sum256([]byte("Bearer " + token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+				s.abuse.record(signalFailedAuth, ap.Addr().Unmap())
+			}
+			s.Audit.Record(AuditEvent{Type: AuditAuthFailure, RemoteIP: adminIP(r), Action: "admin_api"})
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// dropBanned closes tunnels opened by a client that has just been banned.
+func (s *Server) dropBanned(b Ban) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tunnels {
+		ip, _ := netip.ParseAddr(t.clientIP)
+		if b.covers(ip, t.clientID, tokenKey(t.token)) {
+			t.close(CloseBanned)
+		}
+	}
+}
+
+// TunnelSummary describes an open tunnel in the admin API
+type TunnelSummary struct {
+	ID           string    `json:"id"`
+	Host         string    `json:"host"`
+	Port         int       `json:"port"`
+	ClientID     string    `json:"client_id,omitempty"`
+	ClientIP     string    `json:"client_ip"`
+	Opened       time.Time `json:"opened"`
+	UpstreamDown bool      `json:"upstream_down,omitempty"`
+	Paused       bool      `json:"paused,omitempty"`
+
+	// Flagged is why the tunnel was flagged for abuse review, and
+	// AbuseReports how often it was reported, see AbuseRules.PhishingPaths.
+	Flagged      string `json:"flagged,omitempty"`
+	AbuseReports int64  `json:"abuse_reports,omitempty"`
+
+	// Rejected counts visitors turned away by Concurrency; nil when
+	// visitors are unlimited.
+	Rejected *RejectStats `json:"rejected,omitempty"`
+}
+
+// ServerStats is the server-wide summary in the admin API
+type ServerStats struct {
+	Tunnels  int         `json:"tunnels"`  // open tunnels
+	Rejected RejectStats `json:"rejected"` // visitors turned away by Concurrency
+}
+
+func (s *Server) tunnelList() []TunnelSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]TunnelSummary, 0, len(s.tunnels))
+	for _, t := range s.tunnels {
+		sum := TunnelSummary{
+			ID:           t.tunnel.ID,
+			Host:         t.tunnel.Host,
+			Port:         t.tunnel.Port,
+			ClientID:     t.clientID,
+			ClientIP:     t.clientIP,
+			Opened:       t.opened.UTC(),
+			UpstreamDown: t.upstreamDown.Load(),
+			Paused:       t.paused.Load() != nil,
+		}
+		if reason := t.flagged.Load(); reason != nil {
+			sum.Flagged, sum.AbuseReports = *reason, t.abuseReports.Load()
+		}
+		if t.gate != nil {
+			r := t.gate.rejected.stats()
+			sum.Rejected = &r
+		}
+		list = append(list, sum)
+	}
+	slices.SortFunc(list, func(a, b TunnelSummary) int { return a.Opened.Compare(b.Opened) })
+	return list
+}
+
+// auditQuery parses the filters of GET /v1/audit.
+func auditQuery(r *http.Request) (AuditQuery, error) {
+	v := r.URL.Query()
+	q := AuditQuery{
+		Type:     v.Get("type"),
+		TunnelID: v.Get("tunnel_id"),
+		ClientID: v.Get("client_id"),
+		IP:       v.Get("ip"),
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if s := v.Get(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s (expected RFC 3339)", p.name)
+			}
+			*p.dst = t
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// adminIP is the address of an admin API caller.
+func adminIP(r *http.Request) string {
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return ap.Addr().Unmap().String()
+	}
+	return r.RemoteAddr
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": strings.TrimSpace(msg)})
+}
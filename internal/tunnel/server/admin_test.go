@@ -0,0 +1,70 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HeyRistaa/got/internal/tunnel/server"
+)
+
+// admin calls the admin API of srv and decodes the JSON answer into v.
+func admin(t *testing.T, srv *server.Server, method, path string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	srv.AdminHandler("secret").ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v (%s)", method, path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminReportsRejectedVisitors(t *testing.T) {
+	hold := make(chan struct{})
+	started := make(chan struct{}, 1)
+	srv, tun := openTunnel(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-hold
+	}), func(s *server.Server) {
+		s.Concurrency = server.Concurrency{PerTunnel: 1}
+	})
+
+	first := make(chan int)
+	go func() {
+		code, _ := get(t, tun.URL)
+		first <- code
+	}()
+	<-started
+	if code, _ := get(t, tun.URL); code != 0 {
+		t.Fatalf("visitor over the limit got %d, want the connection closed", code)
+	}
+	close(hold)
+	<-first
+
+	var stats server.ServerStats
+	if code := admin(t, srv.Server, http.MethodGet, "/v1/stats", &stats); code != http.StatusOK {
+		t.Fatalf("GET /v1/stats = %d", code)
+	}
+	if stats.Tunnels != 1 || stats.Rejected.QueueFull != 1 {
+		t.Fatalf("stats = %+v, want 1 tunnel and 1 visitor rejected", stats)
+	}
+	var tunnels []server.TunnelSummary
+	admin(t, srv.Server, http.MethodGet, "/v1/tunnels", &tunnels)
+	if len(tunnels) != 1 || tunnels[0].Rejected == nil || tunnels[0].Rejected.QueueFull != 1 {
+		t.Fatalf("tunnels = %+v, want the rejection counted on the tunnel", tunnels)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	srv, _ := openTunnel(t, hello)
+	rec := httptest.NewRecorder()
+	srv.Server.AdminHandler("secret").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET without token = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/logging"
+)
+
+// Audit event types
+const (
+	AuditTunnelOpen  = "tunnel_open"
+	AuditTunnelClose = "tunnel_close"
+	AuditAuthFailure = "auth_failure" // wrong visitor Basic auth or admin API token
+	AuditRateLimit   = "rate_limit"   // control connection or tunnel refused by RateLimits
+	AuditAdmin       = "admin"        // change made through the admin API
+	AuditAbuseReport = "abuse_report" // a tunnel flagged by AbuseRules.PhishingPaths
+)
+
+// Reasons recorded with AuditTunnelClose
+const (
+	CloseClientDisconnect = "client_disconnect"
+	CloseHealthFailure    = "health_failure"
+	CloseAdminKill        = "admin_kill"
+	CloseQuota            = "quota"
+	CloseBanned           = "banned"
+	CloseShutdown         = "server_shutdown"
+)
+
+// AuditEvent is one entry of the audit log. Tokens are recorded as their
+// hash (see clientKey), never in the clear.
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	TunnelID string    `json:"tunnel_id,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	ClientIP string    `json:"client_ip,omitempty"` // the tunnel client's IP
+	Token    string    `json:"token,omitempty"`
+	RemoteIP string    `json:"remote_ip,omitempty"` // visitor or admin API caller
+	Action   string    `json:"action,omitempty"`    // admin action, or what was rate limited or failed auth
+	Target   string    `json:"target,omitempty"`    // what an admin action applied to, e.g. "ip 1.2.3.4"
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// AuditLog appends AuditEvents as JSON lines to a rotating file. It is safe
+// for concurrent use; a nil *AuditLog records nothing.
+type AuditLog struct {
+	f *logging.RotatingFile
+
+	mu sync.Mutex
+}
+
+// OpenAuditLog opens the audit log at path, rotating it once it reaches
+// maxSize bytes and keeping maxBackups rotated files for queries.
+func OpenAuditLog(path string, maxSize int64, maxBackups int) (*AuditLog, error) {
+	f, err := logging.OpenRotating(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f}, nil
+}
+
+// Record appends e, stamping it with the current time if unset.
+func (l *AuditLog) Record(e AuditEvent) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.f.Write(append(line, '\n'))
+}
+
+// Close closes the underlying file.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// AuditQuery selects audit events. Zero fields match everything.
+type AuditQuery struct {
+	Type     string
+	TunnelID string
+	ClientID string
+	IP       string // client or remote IP
+	Since    time.Time
+	Until    time.Time
+	Limit    int // most recent events returned; defaults to 100
+}
+
+// maxAuditResults caps AuditQuery.Limit
+const maxAuditResults = 1000
+
+func (q *AuditQuery) match(e *AuditEvent) bool {
+	return (q.Type == "" || e.Type == q.Type) &&
+		(q.TunnelID == "" || e.TunnelID == q.TunnelID) &&
+		(q.ClientID == "" || e.ClientID == q.ClientID) &&
+		(q.IP == "" || e.ClientIP == q.IP || e.RemoteIP == q.IP) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+// Query returns the most recent events matching q, oldest first, searching
+// the current file and its rotated backups.
+func (l *AuditLog) Query(q AuditQuery) ([]AuditEvent, error) {
+	if l == nil {
+		return nil, nil
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	limit = min(limit, maxAuditResults)
+
+	files, err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var found []AuditEvent
+	for _, f := range files {
+		err := scanAudit(f, func(e *AuditEvent) {
+			if q.match(e) {
+				found = append(found, *e)
+				if len(found) > limit {
+					found = found[1:]
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// open opens the rotated backups, oldest first, and the current file. It
+// holds the lock only while opening, so a rotation cannot move files in
+// between, but the scan does not hold up Record: an open file keeps its
+// contents when it is renamed or removed.
+func (l *AuditLog) open() ([]*os.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	paths := l.f.Backups()
+	slices.Reverse(paths)
+	paths = append(paths, l.f.Path())
+
+	var files []*os.File
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// scanAudit calls fn for each event read from r, skipping lines that do not
+// parse.
+func scanAudit(r io.Reader, fn func(*AuditEvent)) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var e AuditEvent
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			fn(&e)
+		}
+	}
+	return sc.Err()
+}
+
+// auditVisitor records an event about a visitor to a tunnel.
+func (s *Server) auditVisitor(tunnelID string, ip netip.Addr, typ, action string) {
+	e := AuditEvent{Type: typ, TunnelID: tunnelID, RemoteIP: ip.String(), Action: action}
+	s.mu.RLock()
+	if t := s.tunnels[tunnelID]; t != nil {
+		e.Host, e.ClientID = t.tunnel.Host, t.clientID
+	}
+	s.mu.RUnlock()
+	s.Audit.Record(e)
+}
+
+// auditTunnel records a tunnel event with the tunnel's identity.
+func (s *Server) auditTunnel(t *tunnelInfo, typ, reason string) {
+	s.Audit.Record(AuditEvent{
+		Type:     typ,
+		TunnelID: t.tunnel.ID,
+		Host:     t.tunnel.Host,
+		ClientID: t.clientID,
+		ClientIP: t.clientIP,
+		Token:    tokenKey(t.token),
+		Reason:   reason,
+	})
+}
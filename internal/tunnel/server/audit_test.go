@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testAuditLog(t *testing.T, maxSize int64) *AuditLog {
+	t.Helper()
+	l, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"), maxSize, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+func TestAuditQueryAcrossRotation(t *testing.T) {
+	// Each event is about 100 bytes, so the log rotates every few events.
+	l := testAuditLog(t, 300)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 8 {
+		typ := AuditTunnelOpen
+		if i%2 == 1 {
+			typ = AuditTunnelClose
+		}
+		l.Record(AuditEvent{Time: start.Add(time.Duration(i) * time.Minute), Type: typ, TunnelID: fmt.Sprint("t", i)})
+	}
+	if n := len(l.f.Backups()); n == 0 {
+		t.Fatal("log never rotated")
+	}
+
+	got, err := l.Query(AuditQuery{Type: AuditTunnelClose})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range got {
+		ids = append(ids, e.TunnelID)
+	}
+	// The oldest backup beyond the limit is gone, so early events may be
+	// missing, but what is left comes oldest first and ends with t7.
+	if len(ids) == 0 || ids[len(ids)-1] != "t7" {
+		t.Fatalf("closes = %v, want them oldest first ending with t7", ids)
+	}
+
+	got, err = l.Query(AuditQuery{Limit: 2, Since: start.Add(5 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].TunnelID != "t6" || got[1].TunnelID != "t7" {
+		t.Fatalf("limited query = %+v, want t6 and t7", got)
+	}
+}
+
+func TestAuditQueryWhileRecording(t *testing.T) {
+	l := testAuditLog(t, 1<<10)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 500 {
+			l.Record(AuditEvent{Type: AuditRateLimit, ClientIP: "192.0.2.1"})
+		}
+	}()
+	for range 20 {
+		if _, err := l.Query(AuditQuery{IP: "192.0.2.1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	got, err := l.Query(AuditQuery{Limit: maxAuditResults})
+	if err != nil || len(got) == 0 {
+		t.Fatalf("Query = %d events, %v", len(got), err)
+	}
+}
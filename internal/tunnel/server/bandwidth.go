@@ -0,0 +1,473 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/humanize"
+	"github.com/HeyRistaa/got/internal/logging"
+	"github.com/HeyRistaa/got/internal/protocol/control"
+)
+
+// Bandwidth shapes and meters the bytes carried by data connections. Rates
+// apply to each direction separately and allow a one-second burst; quotas
+// count both directions and are tracked per client in UTC days and months.
+// Zero values disable the corresponding limit.
+type Bandwidth struct {
+	TunnelRate   int64 // bytes per second for each tunnel
+	ClientRate   int64 // bytes per second shared by all tunnels of a client
+	DailyQuota   int64 // bytes per client per day
+	MonthlyQuota int64 // bytes per client per month
+
+	// QuotaAction is what happens to a client's tunnels once a quota is used
+	// up: "close" (default) closes them and refuses new ones until the quota
+	// resets; "pause" keeps them open but turns visitors away until then.
+	QuotaAction string
+
+	// UsageFile, when set, persists quota usage across restarts.
+	UsageFile string
+}
+
+// Quota actions
+const (
+	QuotaClose = "close"
+	QuotaPause = "pause"
+)
+
+var errQuotaExceeded = errors.New("transfer quota exceeded")
+
+// ParseBytes parses sizes such as "512K", "10MB" or "5GiB" (1024-based).
+// "", "0" and "off" mean unlimited.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return 0, nil
+	}
+	num := strings.TrimRight(s, "KMGTIBkmgtib")
+	unit := strings.ToUpper(s[len(num):])
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	mult := map[string]float64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}[unit]
+	if mult == 0 {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	return int64(n * mult), nil
+}
+
+// byteBucket is a token bucket of bytes refilled at rate per second.
+// Transfers are taken on credit and the caller sleeps off any debt.
+type byteBucket struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newByteBucket(rate int64) *byteBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &byteBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take removes n bytes and returns how long to wait before sending them.
+func (b *byteBucket) take(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// usageRecord is a client's quota usage, as stored in the usage file.
+type usageRecord struct {
+	Day        string `json:"day"` // 2006-01-02
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"` // 2006-01
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+// clientUsage is the shaping and quota state shared by a client's tunnels.
+type clientUsage struct {
+	in, out *byteBucket
+	meters  int // open meters using it, guarded by usageTable.mu
+
+	mu  sync.Mutex
+	rec usageRecord
+}
+
+// quotaStatus describes a used-up quota.
+type quotaStatus struct {
+	period  string // "daily" or "monthly"
+	limit   int64
+	used    int64
+	resetAt time.Time
+}
+
+// add records n bytes and reports whether a quota is now used up.
+func (u *clientUsage) add(bw *Bandwidth, n int64) *quotaStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(time.Now().UTC())
+	u.rec.DayBytes += n
+	u.rec.MonthBytes += n
+	return u.exceededLocked(bw)
+}
+
+// exceeded reports whether a quota is used up, without recording usage.
+func (u *clientUsage) exceeded(bw *Bandwidth) *quotaStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(time.Now().UTC())
+	return u.exceededLocked(bw)
+}
+
+func (u *clientUsage) exceededLocked(bw *Bandwidth) *quotaStatus {
+	now := time.Now().UTC()
+	if bw.MonthlyQuota > 0 && u.rec.MonthBytes >= bw.MonthlyQuota {
+		y, m, _ := now.Date()
+		return &quotaStatus{"monthly", bw.MonthlyQuota, u.rec.MonthBytes, time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+	if bw.DailyQuota > 0 && u.rec.DayBytes >= bw.DailyQuota {
+		y, m, d := now.Date()
+		return &quotaStatus{"daily", bw.DailyQuota, u.rec.DayBytes, time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)}
+	}
+	return nil
+}
+
+// roll starts new counters when the day or month has changed.
+func (u *clientUsage) roll(now time.Time) {
+	if day := now.Format("2006-01-02"); u.rec.Day != day {
+		u.rec.Day, u.rec.DayBytes = day, 0
+	}
+	if month := now.Format("2006-01"); u.rec.Month != month {
+		u.rec.Month, u.rec.MonthBytes = month, 0
+	}
+}
+
+// usageTable holds clientUsage by client key.
+type usageTable struct {
+	bw *Bandwidth
+
+	mu      sync.Mutex
+	clients map[string]*clientUsage
+}
+
+func newUsageTable(bw *Bandwidth) *usageTable {
+	return &usageTable{bw: bw, clients: make(map[string]*clientUsage)}
+}
+
+// clientKey identifies a client for shaping and quotas: by token when one
+// is presented, otherwise by IP. Tokens are hashed so the usage file never
+// holds them in the clear.
+func clientKey(token, ip string) string {
+	if token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + ip
+}
+
+func (t *usageTable) get(key string) *clientUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.getLocked(key)
+}
+
+// acquire is get for a meter: the entry stays in the table until release,
+// so every tunnel of the client keeps sharing it.
+func (t *usageTable) acquire(key string) *clientUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.getLocked(key)
+	u.meters++
+	return u
+}
+
+// release drops a meter's hold on the entry for key.
+func (t *usageTable) release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u := t.clients[key]; u != nil && u.meters > 0 {
+		u.meters--
+	}
+}
+
+func (t *usageTable) getLocked(key string) *clientUsage {
+	u := t.clients[key]
+	if u == nil {
+		u = &clientUsage{}
+		t.clients[key] = u
+	}
+	if u.in == nil && t.bw.ClientRate > 0 {
+		u.in, u.out = newByteBucket(t.bw.ClientRate), newByteBucket(t.bw.ClientRate)
+	}
+	return u
+}
+
+func (t *usageTable) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var recs map[string]usageRecord
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, rec := range recs {
+		t.clients[key] = &clientUsage{rec: rec}
+	}
+	return nil
+}
+
+// save writes the current month's usage to path atomically. Clients idle
+// since an earlier month are dropped from memory as well, unless an open
+// tunnel still meters through them.
+func (t *usageTable) save(path string) error {
+	now := time.Now().UTC()
+	recs := make(map[string]usageRecord)
+	t.mu.Lock()
+	for key, u := range t.clients {
+		u.mu.Lock()
+		u.roll(now)
+		if u.rec.MonthBytes > 0 || u.meters > 0 {
+			recs[key] = u.rec
+		} else {
+			delete(t.clients, key)
+		}
+		u.mu.Unlock()
+	}
+	t.mu.Unlock()
+
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// saveUsageLoop persists usage every minute until ctx is done. Serve saves
+// once more on shutdown.
+func (s *Server) saveUsageLoop(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			s.saveUsage()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) saveUsage() {
+	if err := s.usage.save(s.Bandwidth.UsageFile); err != nil {
+		s.logger().Error("save usage", logging.Err(err))
+	}
+}
+
+// tunnelQuota returns the used-up quota of the client behind tunnelID, if any.
+func (s *Server) tunnelQuota(tunnelID string) *quotaStatus {
+	s.mu.RLock()
+	t := s.tunnels[tunnelID]
+	s.mu.RUnlock()
+	if t == nil || t.meter == nil {
+		return nil
+	}
+	return t.meter.exceeded()
+}
+
+// meter applies one tunnel's shaping and quotas to its data connections.
+type meter struct {
+	s        *Server
+	tunnelID string
+	in, out  *byteBucket // per-tunnel buckets: towards the client, from it
+	key      string      // see clientKey
+	client   *clientUsage
+
+	mu           sync.Mutex
+	notifiedTill time.Time // a quota notice was sent for the period ending then
+}
+
+func (s *Server) newMeter(tunnelID, key string) *meter {
+	return &meter{
+		s:        s,
+		tunnelID: tunnelID,
+		in:       newByteBucket(s.Bandwidth.TunnelRate),
+		out:      newByteBucket(s.Bandwidth.TunnelRate),
+		key:      key,
+		client:   s.usage.acquire(key),
+	}
+}
+
+// close releases the meter's client usage entry.
+func (m *meter) close() {
+	m.s.usage.release(m.key)
+}
+
+// exceeded returns the client's used-up quota, if any.
+func (m *meter) exceeded() *quotaStatus {
+	return m.client.exceeded(&m.s.Bandwidth)
+}
+
+// transfer shapes n bytes in one direction and records them. It returns
+// errQuotaExceeded once the client's quota has been used up.
+func (m *meter) transfer(n int, tunnel, client *byteBucket) error {
+	m.shape(n, tunnel, client)
+	return m.record(n)
+}
+
+// shape sleeps off the debt of n bytes in the given buckets.
+func (m *meter) shape(n int, tunnel, client *byteBucket) {
+	if d := max(tunnel.take(n), client.take(n)); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// record counts n transferred bytes towards the client's quota.
+func (m *meter) record(n int) error {
+	if q := m.client.add(&m.s.Bandwidth, int64(n)); q != nil {
+		m.quotaExceeded(q)
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// quotaExceeded notifies the client once per quota period and, with the
+// close action, closes the tunnel.
+func (m *meter) quotaExceeded(q *quotaStatus) {
+	m.mu.Lock()
+	notified := m.notifiedTill.Equal(q.resetAt)
+	m.notifiedTill = q.resetAt
+	m.mu.Unlock()
+	if notified {
+		return
+	}
+
+	m.s.mu.RLock()
+	t := m.s.tunnels[m.tunnelID]
+	m.s.mu.RUnlock()
+	if t == nil {
+		return
+	}
+	action := m.s.Bandwidth.quotaAction()
+	t.log.Warn("transfer quota exceeded", "period", q.period, "limit", q.limit, "action", action)
+	msg := quotaMessage(m.tunnelID, action, q)
+	m.s.notifyTunnel(t, WebhookQuotaExceeded, "", msg.Message)
+	_ = control.WriteJSONLine(t.ctlConn, msg)
+	if action == QuotaClose {
+		t.close(CloseQuota)
+	}
+}
+
+func quotaMessage(tunnelID, action string, q *quotaStatus) control.QuotaExceeded {
+	verb := "closed"
+	if action == QuotaPause {
+		verb = "paused"
+	}
+	return control.QuotaExceeded{
+		Type:     "quota_exceeded",
+		TunnelID: tunnelID,
+		Period:   q.period,
+		Limit:    q.limit,
+		Used:     q.used,
+		Action:   action,
+		ResetAt:  q.resetAt,
+		Message: fmt.Sprintf("%s transfer quota of %s used up; tunnel %s until %s",
+			q.period, humanize.Bytes(q.limit), verb, q.resetAt.Format("2006-01-02 15:04 MST")),
+	}
+}
+
+func (bw *Bandwidth) quotaAction() string {
+	if bw.QuotaAction == QuotaPause {
+		return QuotaPause
+	}
+	return QuotaClose
+}
+
+// burst is the smallest configured rate, or 0 when unshaped.
+func (bw *Bandwidth) burst() int {
+	var b int64
+	for _, r := range []int64{bw.TunnelRate, bw.ClientRate} {
+		if r > 0 && (b == 0 || r < b) {
+			b = r
+		}
+	}
+	return int(min(b, 1<<30))
+}
+
+func (bw *Bandwidth) enabled() bool {
+	return bw.TunnelRate > 0 || bw.ClientRate > 0 || bw.DailyQuota > 0 || bw.MonthlyQuota > 0
+}
+
+// meteredConn is a data connection shaped and counted by a meter. Reads
+// carry bytes from the client towards visitors, writes the reverse.
+type meteredConn struct {
+	net.Conn
+	m *meter
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	// Keep single reads within a second's worth of bytes so shaping stays smooth
+	if limit := c.m.s.Bandwidth.burst(); limit > 0 && len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if qerr := c.m.transfer(n, c.m.out, c.m.client.out); qerr != nil {
+			return n, qerr
+		}
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	if c.m.exceeded() != nil {
+		return 0, errQuotaExceeded
+	}
+	// Shape before writing, but only count what was actually written
+	c.m.shape(len(p), c.m.in, c.m.client.in)
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		if qerr := c.m.record(n); qerr != nil && err == nil {
+			err = qerr
+		}
+	}
+	return n, err
+}
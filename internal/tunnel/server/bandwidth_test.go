@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]int64{
+		"":      0,
+		"off":   0,
+		"512":   512,
+		"512K":  512 << 10,
+		"10MB":  10 << 20,
+		"5GiB":  5 << 30,
+		"1.5kb": 1536,
+	} {
+		if got, err := ParseBytes(in); err != nil || got != want {
+			t.Errorf("ParseBytes(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"x", "-1K", "5Q"} {
+		if _, err := ParseBytes(bad); err == nil {
+			t.Errorf("ParseBytes(%q) succeeded", bad)
+		}
+	}
+}
+
+// shortConn accepts only max bytes per write and then fails.
+type shortConn struct {
+	net.Conn
+	max int
+}
+
+func (c *shortConn) Write(p []byte) (int, error) {
+	if len(p) > c.max {
+		return c.max, errors.New("short write")
+	}
+	return len(p), nil
+}
+
+func TestMeteredWriteCountsWrittenBytes(t *testing.T) {
+	s := &Server{Bandwidth: Bandwidth{DailyQuota: 1 << 20}}
+	s.usage = newUsageTable(&s.Bandwidth)
+	m := s.newMeter("t1", "client")
+	c := &meteredConn{Conn: &shortConn{max: 40}, m: m}
+
+	n, err := c.Write(make([]byte, 100))
+	if n != 40 || err == nil {
+		t.Fatalf("Write = %d, %v; want 40 and the write error", n, err)
+	}
+	if used := m.client.rec.DayBytes; used != 40 {
+		t.Fatalf("counted %d bytes, want the 40 written", used)
+	}
+}
+
+func TestMeteredWriteStopsAtQuota(t *testing.T) {
+	s := &Server{Bandwidth: Bandwidth{DailyQuota: 100}}
+	s.usage = newUsageTable(&s.Bandwidth)
+	m := s.newMeter("t1", "client")
+	c := &meteredConn{Conn: &shortConn{max: 1 << 20}, m: m}
+
+	if _, err := c.Write(make([]byte, 100)); !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("Write reaching the quota = %v, want errQuotaExceeded", err)
+	}
+	if n, err := c.Write([]byte("x")); n != 0 || !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("Write past the quota = %d, %v; want refused", n, err)
+	}
+}
+
+func TestUsageSaveKeepsMeteredClients(t *testing.T) {
+	s := &Server{Bandwidth: Bandwidth{MonthlyQuota: 1 << 20}}
+	s.usage = newUsageTable(&s.Bandwidth)
+	path := filepath.Join(t.TempDir(), "usage.json")
+	m := s.newMeter("t1", "client")
+	// The tunnel opened last month and has moved nothing since.
+	m.client.rec = usageRecord{Month: "2000-01", MonthBytes: 500}
+
+	if err := s.usage.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if m2 := s.newMeter("t2", "client"); m2.client != m.client {
+		t.Fatal("a new tunnel of the client got a separate usage entry")
+	} else {
+		m2.close()
+	}
+	_ = m.record(10)
+	if err := s.usage.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.usage.get("client").rec.MonthBytes; got != 10 {
+		t.Fatalf("month bytes = %d, want the 10 moved this month", got)
+	}
+
+	// Once idle and unmetered, the client is dropped at the next new month.
+	m.close()
+	m.client.rec.Month, m.client.rec.MonthBytes = "2000-01", 0
+	if err := s.usage.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.usage.clients["client"]; ok {
+		t.Fatal("idle client kept in memory")
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Ban kinds
+const (
+	BanIP       = "ip"
+	BanCIDR     = "cidr"
+	BanClientID = "client_id"
+	BanToken    = "token"
+)
+
+// Ban keeps a client out of the server. IP and CIDR bans also turn away
+// visitors. Token bans store the token's hash (see clientKey), never the
+// token itself.
+type Ban struct {
+	Kind    string    `json:"kind"` // "ip", "cidr", "client_id" or "token"
+	Value   string    `json:"value"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitzero"` // zero means permanent
+	Auto    bool      `json:"auto,omitempty"`   // added by the abuse detector
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !b.Expires.IsZero() && !now.Before(b.Expires)
+}
+
+// until is how long the ban has left, or 0 if it is permanent.
+func (b *Ban) until() time.Duration {
+	if b.Expires.IsZero() {
+		return 0
+	}
+	return time.Until(b.Expires)
+}
+
+// BanList is a set of bans, optionally persisted as JSON to a file. It is
+// safe for concurrent use; a nil *BanList bans nothing.
+type BanList struct {
+	path string
+
+	mu   sync.Mutex
+	bans []Ban
+}
+
+// LoadBanList reads the ban list at path, starting empty if the file does
+// not exist. An empty path keeps the list in memory only.
+func LoadBanList(path string) (*BanList, error) {
+	l := &BanList{path: path}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.bans); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range l.bans {
+		if err := l.bans[i].normalize(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return l, nil
+}
+
+// normalize validates the ban and puts IPs, CIDRs and tokens in the form
+// they are matched in.
+func (b *Ban) normalize() error {
+	switch b.Kind {
+	case BanIP:
+		ip, err := netip.ParseAddr(b.Value)
+		if err != nil {
+			return fmt.Errorf("invalid ip ban %q", b.Value)
+		}
+		b.Value = ip.Unmap().String()
+	case BanCIDR:
+		p, err := netip.ParsePrefix(b.Value)
+		if err != nil {
+			return fmt.Errorf("invalid cidr ban %q", b.Value)
+		}
+		b.Value = p.Masked().String()
+	case BanClientID:
+	case BanToken:
+		if !tokenKeyPattern(b.Value) {
+			b.Value = tokenKey(b.Value)
+		}
+	default:
+		return fmt.Errorf("invalid ban kind %q", b.Kind)
+	}
+	if b.Value == "" {
+		return errors.New("empty ban value")
+	}
+	return nil
+}
+
+// tokenKeyPattern reports whether v is already a hashed token key.
+func tokenKeyPattern(v string) bool {
+	return len(v) == len("token:")+16 && v[:len("token:")] == "token:"
+}
+
+// Add adds or replaces the ban for b's kind and value and saves the list.
+func (l *BanList) Add(b Ban) (Ban, error) {
+	if err := b.normalize(); err != nil {
+		return Ban{}, err
+	}
+	if b.Created.IsZero() {
+		b.Created = time.Now().UTC()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bans = slices.DeleteFunc(l.bans, func(x Ban) bool { return x.Kind == b.Kind && x.Value == b.Value })
+	l.bans = append(l.bans, b)
+	return b, l.saveLocked()
+}
+
+// Remove lifts the ban for kind and value, reporting whether there was one.
+func (l *BanList) Remove(kind, value string) (bool, error) {
+	b := Ban{Kind: kind, Value: value}
+	if err := b.normalize(); err != nil {
+		return false, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(l.bans)
+	l.bans = slices.DeleteFunc(l.bans, func(x Ban) bool { return x.Kind == b.Kind && x.Value == b.Value })
+	if len(l.bans) == n {
+		return false, nil
+	}
+	return true, l.saveLocked()
+}
+
+// List returns the bans in force.
+func (l *BanList) List() []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked()
+	return slices.Clone(l.bans)
+}
+
+// matchIP returns the ban covering ip, if any.
+func (l *BanList) matchIP(ip netip.Addr) *Ban {
+	return l.match(ip, "", "")
+}
+
+// matchClient returns the ban covering the client ID or token, if any.
+func (l *BanList) matchClient(clientID, token string) *Ban {
+	return l.match(netip.Addr{}, clientID, tokenKey(token))
+}
+
+func (l *BanList) match(ip netip.Addr, clientID, tokenKey string) *Ban {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for i := range l.bans {
+		if b := &l.bans[i]; !b.expired(now) && b.covers(ip, clientID, tokenKey) {
+			found := *b
+			return &found
+		}
+	}
+	return nil
+}
+
+// covers reports whether the ban applies to a client at ip presenting
+// clientID and the token hashed to tokenKey. Empty arguments match nothing.
+func (b *Ban) covers(ip netip.Addr, clientID, tokenKey string) bool {
+	switch b.Kind {
+	case BanIP:
+		return ip.IsValid() && b.Value == ip.Unmap().String()
+	case BanCIDR:
+		p, err := netip.ParsePrefix(b.Value)
+		return err == nil && ip.IsValid() && p.Contains(ip.Unmap())
+	case BanClientID:
+		return clientID != "" && b.Value == clientID
+	case BanToken:
+		return tokenKey != "" && b.Value == tokenKey
+	}
+	return false
+}
+
+// tokenKey is the form a token is banned under, or "" for no token.
+func tokenKey(token string) string {
+	if token == "" {
+		return ""
+	}
+	return clientKey(token, "")
+}
+
+// pruneLocked drops expired bans, saving the list if any were dropped.
+func (l *BanList) pruneLocked() {
+	now := time.Now()
+	n := len(l.bans)
+	l.bans = slices.DeleteFunc(l.bans, func(b Ban) bool { return b.expired(now) })
+	if len(l.bans) != n {
+		_ = l.saveLocked()
+	}
+}
+
+func (l *BanList) saveLocked() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/HeyRistaa/got/internal/tunnel/server"
+)
+
+func addBan(t *testing.T, srv *server.Server, kind, value string) {
+	t.Helper()
+	if _, err := srv.Bans.Add(server.Ban{Kind: kind, Value: value}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBannedVisitorDirect(t *testing.T) {
+	srv, tun := openTunnel(t, hello, func(s *server.Server) {
+		s.TrustedProxies = nil
+	})
+	addBan(t, srv.Server, server.BanIP, "127.0.0.1")
+	if code, _ := get(t, tun.URL); code != 0 {
+		t.Fatalf("banned visitor got %d, want the connection closed", code)
+	}
+}
+
+func TestBanSkipsTrustedProxy(t *testing.T) {
+	// Loopback is a trusted proxy by default: banning it would turn away
+	// every visitor behind it, so only the edge bans by X-Forwarded-For.
+	srv, tun := openTunnel(t, hello)
+	addBan(t, srv.Server, server.BanIP, "127.0.0.1")
+	if code, body := get(t, tun.URL); code != http.StatusOK {
+		t.Fatalf("visit through the proxy = %d %s", code, body)
+	}
+}
+
+func TestBannedClientRefused(t *testing.T) {
+	srv, _ := openTunnel(t, hello)
+	addBan(t, srv.Server, server.BanClientID, "gottest")
+	if tun, err := srv.Open(hello); err == nil {
+		_ = tun.Close()
+		t.Fatal("banned client opened a tunnel")
+	}
+}
+
+func TestPhishingFlagsWithoutBan(t *testing.T) {
+	srv, _ := openTunnel(t, hello, func(s *server.Server) {
+		s.Abuse.PhishingPaths = []string{"/wp-admin/*"}
+	})
+	url := openEdge(t, srv, hello)
+	for i := 0; i < 2; i++ {
+		if code, body := get(t, url+"/wp-admin/login.php"); code != http.StatusOK {
+			t.Fatalf("phishing path = %d %s", code, body)
+		}
+	}
+
+	var tunnels []server.TunnelSummary
+	admin(t, srv.Server, http.MethodGet, "/v1/tunnels", &tunnels)
+	var flagged int
+	for _, sum := range tunnels {
+		if sum.Flagged != "" {
+			flagged++
+			if sum.AbuseReports != 2 {
+				t.Fatalf("flagged tunnel = %+v, want 2 reports", sum)
+			}
+		}
+	}
+	if flagged != 1 {
+		t.Fatalf("tunnels = %+v, want the edge tunnel flagged", tunnels)
+	}
+	if bans := srv.Server.Bans.List(); len(bans) != 0 {
+		t.Fatalf("bans = %+v, want none", bans)
+	}
+	if code, body := get(t, url); code != http.StatusOK {
+		t.Fatalf("flagged tunnel = %d %s, want it still open", code, body)
+	}
+}
+
+func TestPhishingPathsKeepRawTunnels(t *testing.T) {
+	srv, _ := openTunnel(t, hello, func(s *server.Server) {
+		s.Abuse.PhishingPaths = []string{"/wp-admin/*"}
+	})
+	if got := echo(t, openEcho(t, srv)); got != "SSH-2.0-test\r\n" {
+		t.Fatalf("raw tunnel echoed %q", got)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Concurrency caps the visitor connections served at once. Raw tunnels count
+// TCP connections; HTTP edge tunnels count requests in flight, since Caddy
+// pools connections across visitors. Zero values mean unlimited.
+type Concurrency struct {
+	PerTunnel int // concurrent visitors per tunnel
+	PerClient int // concurrent visitors across all tunnels of a client
+
+	// Visitors arriving while a tunnel is saturated wait in a queue of up to
+	// QueueSize for at most QueueTimeout. Beyond that they are turned away:
+	// raw connections are closed, edge requests get 503. A zero QueueSize
+	// or QueueTimeout turns visitors away as soon as the tunnel is full.
+	QueueSize    int
+	QueueTimeout time.Duration
+}
+
+// DefaultConcurrency leaves connections unlimited but gives a limit, once
+// set, a short queue to absorb bursts.
+func DefaultConcurrency() Concurrency {
+	return Concurrency{QueueSize: 64, QueueTimeout: 10 * time.Second}
+}
+
+func (c *Concurrency) enabled() bool { return c.PerTunnel > 0 || c.PerClient > 0 }
+
+// RejectStats counts visitors turned away because a tunnel or client was
+// saturated.
+type RejectStats struct {
+	QueueFull int64 `json:"queue_full"` // rejected on arrival, queue already full
+	Timeout   int64 `json:"timeout"`    // waited QueueTimeout without getting a slot
+}
+
+type rejectCounters struct {
+	queueFull, timeout atomic.Int64
+}
+
+func (r *rejectCounters) stats() RejectStats {
+	return RejectStats{QueueFull: r.queueFull.Load(), Timeout: r.timeout.Load()}
+}
+
+// Rejected returns the server-wide count of rejected visitor connections.
+// The admin API reports it under /v1/stats, and per tunnel in /v1/tunnels.
+func (s *Server) Rejected() RejectStats { return s.rejected.stats() }
+
+var (
+	errQueueFull    = errors.New("tunnel saturated: queue full")
+	errQueueTimeout = errors.New("tunnel saturated: timed out waiting for a slot")
+)
+
+// visitorGate hands out visitor slots for one tunnel. A nil semaphore is
+// unlimited.
+type visitorGate struct {
+	tunnel, client chan struct{}
+	queue          int
+	timeout        time.Duration
+
+	waiting  atomic.Int32
+	rejected rejectCounters // this tunnel's rejections
+	global   *rejectCounters
+}
+
+func newSemaphore(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// tryAcquire takes a slot without waiting.
+func (g *visitorGate) tryAcquire() (release func(), ok bool) {
+	select {
+	case g.tunnel <- struct{}{}:
+	default:
+		if g.tunnel != nil {
+			return nil, false
+		}
+	}
+	select {
+	case g.client <- struct{}{}:
+	default:
+		if g.client != nil {
+			g.releaseTunnel()
+			return nil, false
+		}
+	}
+	return g.release, true
+}
+
+// enqueue reserves a place in the queue, or counts a rejection if it is full.
+// Callers that got a place must call wait.
+func (g *visitorGate) enqueue() error {
+	if int(g.waiting.Add(1)) > g.queue {
+		g.waiting.Add(-1)
+		g.reject(&g.rejected.queueFull, &g.global.queueFull)
+		return errQueueFull
+	}
+	return nil
+}
+
+// wait blocks for a slot until the queue timeout or ctx is done, then gives
+// up its place in the queue.
+func (g *visitorGate) wait(ctx context.Context) (release func(), err error) {
+	defer g.waiting.Add(-1)
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	if g.tunnel != nil {
+		select {
+		case g.tunnel <- struct{}{}:
+		case <-ctx.Done():
+			return nil, g.timedOut(ctx)
+		}
+	}
+	if g.client != nil {
+		select {
+		case g.client <- struct{}{}:
+		case <-ctx.Done():
+			g.releaseTunnel()
+			return nil, g.timedOut(ctx)
+		}
+	}
+	return g.release, nil
+}
+
+// acquire takes a slot, queueing if the tunnel is saturated.
+func (g *visitorGate) acquire(ctx context.Context) (release func(), err error) {
+	if release, ok := g.tryAcquire(); ok {
+		return release, nil
+	}
+	if err := g.enqueue(); err != nil {
+		return nil, err
+	}
+	return g.wait(ctx)
+}
+
+func (g *visitorGate) timedOut(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		g.reject(&g.rejected.timeout, &g.global.timeout)
+		return errQueueTimeout
+	}
+	return ctx.Err()
+}
+
+func (g *visitorGate) reject(local, global *atomic.Int64) {
+	local.Add(1)
+	global.Add(1)
+}
+
+func (g *visitorGate) release() {
+	if g.client != nil {
+		<-g.client
+	}
+	g.releaseTunnel()
+}
+
+func (g *visitorGate) releaseTunnel() {
+	if g.tunnel != nil {
+		<-g.tunnel
+	}
+}
+
+// clientSlots shares a semaphore between the tunnels of each client,
+// dropping it when the client's last tunnel closes.
+type clientSlots struct {
+	mu sync.Mutex
+	m  map[string]*clientSlot
+}
+
+type clientSlot struct {
+	sem  chan struct{}
+	refs int
+}
+
+func (c *clientSlots) get(key string, n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]*clientSlot)
+	}
+	cs := c.m[key]
+	if cs == nil {
+		cs = &clientSlot{sem: newSemaphore(n)}
+		c.m[key] = cs
+	}
+	cs.refs++
+	return cs.sem
+}
+
+func (c *clientSlots) put(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cs := c.m[key]; cs != nil {
+		if cs.refs--; cs.refs <= 0 {
+			delete(c.m, key)
+		}
+	}
+}
+
+// newVisitorGate returns the gate for a new tunnel of the client with key,
+// or nil when concurrency is unlimited.
+func (s *Server) newVisitorGate(key string) *visitorGate {
+	if !s.Concurrency.enabled() {
+		return nil
+	}
+	queue := s.Concurrency.QueueSize
+	if s.Concurrency.QueueTimeout <= 0 {
+		queue = 0 // waiting no time at all is not queueing
+	}
+	return &visitorGate{
+		tunnel:  newSemaphore(s.Concurrency.PerTunnel),
+		client:  s.clientSlots.get(key, s.Concurrency.PerClient),
+		queue:   queue,
+		timeout: s.Concurrency.QueueTimeout,
+		global:  &s.rejected,
+	}
+}
+
+// visitorSlots limits requests in flight on an edge tunnel, answering 503
+// once the tunnel is saturated and its queue is full or times out.
+func visitorSlots(g *visitorGate, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := g.acquire(r.Context())
+		if err != nil {
+			if r.Context().Err() == nil {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "503 Service Unavailable: too many concurrent visitors", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testGate(c Concurrency) *visitorGate {
+	s := &Server{Concurrency: c}
+	return s.newVisitorGate("client")
+}
+
+func TestVisitorGateQueues(t *testing.T) {
+	g := testGate(Concurrency{PerTunnel: 1, QueueSize: 1, QueueTimeout: time.Second})
+	release, err := g.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		r, err := g.acquire(context.Background())
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	// Wait for the second visitor to queue, then a third finds it full
+	for g.waiting.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := g.acquire(context.Background()); !errors.Is(err, errQueueFull) {
+		t.Fatalf("third visitor: %v, want errQueueFull", err)
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatalf("queued visitor: %v", err)
+	}
+	if r := g.rejected.stats(); r != (RejectStats{QueueFull: 1}) {
+		t.Fatalf("rejected = %+v", r)
+	}
+}
+
+func TestVisitorGateTimeout(t *testing.T) {
+	g := testGate(Concurrency{PerTunnel: 1, QueueSize: 1, QueueTimeout: 10 * time.Millisecond})
+	release, _ := g.acquire(context.Background())
+	defer release()
+	if _, err := g.acquire(context.Background()); !errors.Is(err, errQueueTimeout) {
+		t.Fatalf("acquire = %v, want errQueueTimeout", err)
+	}
+	if r := g.global.stats(); r.Timeout != 1 {
+		t.Fatalf("server-wide rejected = %+v, want one timeout", r)
+	}
+}
+
+func TestVisitorGateZeroTimeoutDoesNotQueue(t *testing.T) {
+	g := testGate(Concurrency{PerTunnel: 1, QueueSize: 64})
+	release, _ := g.acquire(context.Background())
+	defer release()
+	start := time.Now()
+	if _, err := g.acquire(context.Background()); !errors.Is(err, errQueueFull) {
+		t.Fatalf("acquire = %v, want errQueueFull", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("visitor waited although queueing is off")
+	}
+}
+
+func TestVisitorGatePerClient(t *testing.T) {
+	s := &Server{Concurrency: Concurrency{PerClient: 1}}
+	a, b := s.newVisitorGate("client"), s.newVisitorGate("client")
+	release, ok := a.tryAcquire()
+	if !ok {
+		t.Fatal("first slot refused")
+	}
+	if _, ok := b.tryAcquire(); ok {
+		t.Fatal("second tunnel of the client got a slot past PerClient")
+	}
+	release()
+	if r, ok := b.tryAcquire(); !ok {
+		t.Fatal("slot not freed")
+	} else {
+		r()
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/logging"
+	"github.com/HeyRistaa/got/internal/offline"
+	"github.com/HeyRistaa/got/internal/protocol/control"
+)
+
+// The edge serves a tunnel's public listener as HTTP instead of piping raw
+// bytes, so per-request policy (such as basic auth) runs on the server before
+// any ConnRequest is sent to the client. Caddy pools upstream connections
+// across visitors, so policy has to be checked per request, not per
+// connection. Tunnels that need no policy keep the raw TCP bridge.
+
+// needsEdge reports whether a tunnel's options require the HTTP edge. The
+// access log, phishing paths and trace injection do not: they apply to
+// tunnels that are served as HTTP anyway, since forcing raw TCP tunnels
+// onto the edge would break them.
+func (s *Server) needsEdge(req *control.OpenTunnel) bool {
+	// IP lists need the edge too: behind Caddy every connection comes from
+	// loopback and the visitor is only known from X-Forwarded-For.
+	return req.BasicAuth != nil || len(req.AllowCIDRs) > 0 || len(req.DenyCIDRs) > 0 || req.OIDC != nil
+}
+
+// serveEdge serves HTTP on ln, proxying allowed requests to the client over
+// data connections obtained with dialClient.
+func (s *Server) serveEdge(ln net.Listener, tunnelID string, port int, req *control.OpenTunnel, filter *ipFilter, gate *visitorGate) {
+	idleConnTimeout := 90 * time.Second
+	if s.Timeouts.Idle > 0 {
+		idleConnTimeout = min(idleConnTimeout, s.Timeouts.Idle)
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			conn, err := s.dialClient(ctx, tunnelID)
+			if err != nil {
+				return nil, err
+			}
+			return s.withDeadlines(conn), nil
+		},
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     idleConnTimeout,
+		DisableCompression:  true,
+	}
+	defer transport.CloseIdleConnections()
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = pr.In.Host
+			pr.Out.Host = pr.In.Host
+			// Pass Caddy's forwarding headers through untouched, as the raw
+			// bridge would.
+			for _, h := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+				if v, ok := pr.In.Header[h]; ok {
+					pr.Out.Header[h] = v
+				}
+			}
+			if s.TraceInject {
+				injectTraceparent(pr.Out, pr.In)
+			}
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if q := s.tunnelQuota(tunnelID); q != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(time.Until(q.resetAt))))
+				http.Error(w, "bandwidth quota exceeded", http.StatusServiceUnavailable)
+				return
+			}
+			s.logger().Warn("edge proxy", logging.TunnelID, tunnelID, logging.RemoteIP, s.visitorIP(r).String(), logging.Err(err))
+			http.Error(w, "tunnel client unavailable", http.StatusBadGateway)
+		},
+	}
+
+	var h http.Handler = proxy
+	if gate != nil {
+		h = visitorSlots(gate, h)
+	}
+	h = s.offlineHandler(tunnelID, h)
+	h = s.pauseHandler(tunnelID, h)
+	if len(s.Abuse.PhishingPaths) > 0 {
+		h = s.phishingGuard(tunnelID, h)
+	}
+	if req.OIDC != nil && s.OIDC != nil {
+		h = s.OIDC.Gate(req.OIDC.EmailDomains, h)
+	}
+	if req.BasicAuth != nil {
+		h = basicAuth(req.BasicAuth.Username, req.BasicAuth.Password, h, func(r *http.Request) {
+			ip := s.visitorIP(r)
+			s.abuse.record(signalFailedAuth, ip)
+			s.auditVisitor(tunnelID, ip, AuditAuthFailure, "basic_auth")
+		})
+	}
+	h = s.visitorLimit(tunnelID, h)
+	h = s.banHandler(h)
+	// The idle and lifetime timeouts also cover request bodies and upgraded
+	// connections, which http.Server leaves alone once the headers are read.
+	ln = &deadlineListener{Listener: s.withKeepAlive(ln), s: s}
+	if filter != nil {
+		h = s.ipFilterHandler(filter, h)
+		ln = &filterListener{Listener: ln, s: s, f: filter}
+	}
+	if st := s.tunnelStats(tunnelID); st != nil {
+		h = s.countRequests(st, h)
+		ln = &statsListener{Listener: ln, st: st}
+	}
+	if s.Tracer != nil {
+		h = s.traceRequest(tunnelID, h)
+	}
+	if s.AccessLog.enabled() {
+		h = s.accessLog(tunnelID, h)
+	}
+
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: s.Timeouts.Handshake,
+		ReadTimeout:       s.Timeouts.MaxLifetime,
+		IdleTimeout:       s.Timeouts.Idle,
+		ErrorLog:          slog.NewLogLogger(s.logger().With(logging.TunnelID, tunnelID).Handler(), slog.LevelWarn),
+	}
+	s.mu.Lock()
+	if t := s.tunnels[tunnelID]; t != nil {
+		t.edge = srv
+	}
+	s.mu.Unlock()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		s.logger().Error("edge serve", logging.TunnelID, tunnelID, "port", port, logging.Err(err))
+	}
+}
+
+// basicAuth rejects requests without the tunnel's credentials. The
+// Authorization header is consumed so it never reaches the local app.
+// failed is called for requests that sent wrong credentials.
+func basicAuth(username, password string, next http.Handler, failed func(*http.Request)) http.Handler {
+	wantUser := sha256.Sum256([]byte(username))
+	wantPass := sha256.Sum256([]byte(password))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		gotUser := sha256.Sum256([]byte(u))
+		gotPass := sha256.Sum256([]byte(p))
+		if !ok || subtle.ConstantTimeCompare(gotUser[:], wantUser[:])&subtle.ConstantTimeCompare(gotPass[:], wantPass[:]) != 1 {
+			if ok {
+				failed(r)
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="got tunnel", charset="UTF-8"`)
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Header.Del("Authorization")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// visitorLimit applies the per-visitor rate limit to each request, answering
+// 429 with Retry-After when the visitor's bucket is empty.
+func (s *Server) visitorLimit(tunnelID string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := s.visitorLimiter.Allow(tunnelID + "|" + limiterKey(s.visitorIP(r))); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// offlineHandler answers visitors with the offline page while the client
+// reports its local app as down, without a round trip through the tunnel.
+func (s *Server) offlineHandler(tunnelID string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		t := s.tunnels[tunnelID]
+		s.mu.RUnlock()
+		if t != nil && t.upstreamDown.Load() {
+			offline.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	for name, tc := range map[string]struct {
+		user, pass string
+		header     bool // send an Authorization header at all
+		status     int
+		failed     int // calls to the failure callback
+	}{
+		"valid":          {user: "alice", pass: "s3cret", header: true, status: http.StatusOK},
+		"wrong user":     {user: "bob", pass: "s3cret", header: true, status: http.StatusUnauthorized, failed: 1},
+		"wrong password": {user: "alice", pass: "guess", header: true, status: http.StatusUnauthorized, failed: 1},
+		// A browser's first request carries no credentials; it is not a
+		// failed attempt.
+		"missing header": {status: http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var failed int
+			var forwarded *http.Request
+			h := basicAuth("alice", "s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			}), func(*http.Request) { failed++ })
+
+			r := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+			if tc.header {
+				r.SetBasicAuth(tc.user, tc.pass)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d", w.Code, tc.status)
+			}
+			if failed != tc.failed {
+				t.Fatalf("failure callback ran %d times, want %d", failed, tc.failed)
+			}
+			if tc.status != http.StatusOK {
+				if forwarded != nil {
+					t.Fatal("unauthorized request reached the tunnel")
+				}
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Fatal("401 without WWW-Authenticate")
+				}
+				return
+			}
+			// The tunnel's credentials are the edge's business, not the
+			// local app's.
+			if got := forwarded.Header.Get("Authorization"); got != "" {
+				t.Fatalf("Authorization %q was forwarded", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/protocol/control"
+	"github.com/HeyRistaa/got/internal/tunnel/health"
+)
+
+// minHealthInterval keeps clients from turning health checks into load
+const minHealthInterval = 5 * time.Second
+
+// healthPolicy merges a client's requested policy over the defaults.
+// ok is false when the client disabled health checks.
+func healthPolicy(req *control.HealthPolicy) (p health.Policy, ok bool, err error) {
+	p = health.DefaultPolicy()
+	if req == nil {
+		return p, true, nil
+	}
+	if req.Disabled {
+		return p, false, nil
+	}
+	if req.Path != "" {
+		if req.Path[0] != '/' {
+			return p, false, fmt.Errorf("invalid health path %q", req.Path)
+		}
+		p.Path = req.Path
+	}
+	if req.ExpectStatus != "" {
+		if p.Expect, err = health.ParseStatusSet(req.ExpectStatus); err != nil {
+			return p, false, fmt.Errorf("invalid health expect_status: %w", err)
+		}
+	}
+	if req.IntervalSeconds > 0 {
+		p.Interval = max(time.Duration(req.IntervalSeconds)*time.Second, minHealthInterval)
+	}
+	if req.TimeoutSeconds > 0 {
+		p.Timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	p.Timeout = min(p.Timeout, p.Interval)
+	if req.Threshold > 0 {
+		p.Threshold = req.Threshold
+	}
+	switch req.Action {
+	case "":
+	case health.ActionWarn, health.ActionClose:
+		p.Action = req.Action
+	default:
+		return p, false, fmt.Errorf("invalid health action %q (expected warn or close)", req.Action)
+	}
+	return p, true, nil
+}
+
+// startHealthCheck probes the tunnel through its own data connections and
+// reports each result to the client until ctx is done. A paused client
+// answers every probe with its maintenance page, and one out of quota gets
+// no visitors either, so probes wait until the tunnel serves again.
+func (s *Server) startHealthCheck(ctx context.Context, t *tunnelInfo, p health.Policy) {
+	tid := t.tunnel.ID
+	dial := func(ctx context.Context) (net.Conn, error) { return s.probeClient(ctx, tid) }
+	skip := func() bool {
+		return t.paused.Load() != nil || (t.meter != nil && t.meter.exceeded() != nil)
+	}
+	report := func(r health.Result) {
+		if !r.OK {
+			t.log.Warn("health check failed", "failures", r.Failures, "threshold", p.Threshold, "error", r.Error)
+			if r.Failures == p.Threshold {
+				s.notifyTunnel(t, WebhookHealthFailed, "", fmt.Sprintf("%d health checks in a row failed: %s", r.Failures, r.Error))
+			}
+		}
+		_ = control.WriteJSONLine(t.ctlConn, control.HealthReport{
+			Type:      "health_report",
+			TunnelID:  tid,
+			OK:        r.OK,
+			Status:    r.Status,
+			Error:     r.Error,
+			LatencyMS: r.Latency.Milliseconds(),
+			Failures:  r.Failures,
+			Threshold: p.Threshold,
+			Action:    p.Action,
+		})
+	}
+	s.tunnelManager.StartHealthCheck(ctx, t.tunnel, p, dial, skip, report, func() {
+		t.close(CloseHealthFailure)
+	})
+}
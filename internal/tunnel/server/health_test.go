@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/protocol/control"
+	"github.com/HeyRistaa/got/internal/tunnel"
+	"github.com/HeyRistaa/got/internal/tunnel/health"
+)
+
+func TestHealthPolicyDefaults(t *testing.T) {
+	p, ok, err := healthPolicy(nil)
+	if err != nil || !ok {
+		t.Fatalf("healthPolicy(nil) = %v, %v", ok, err)
+	}
+	if want := health.DefaultPolicy(); p.Path != want.Path || p.Interval != want.Interval || p.Action != health.ActionWarn {
+		t.Fatalf("policy = %+v, want the defaults", p)
+	}
+	if _, ok, err := healthPolicy(&control.HealthPolicy{Disabled: true}); ok || err != nil {
+		t.Fatalf("disabled policy = %v, %v, want checks off", ok, err)
+	}
+}
+
+func TestHealthPolicyOverrides(t *testing.T) {
+	p, ok, err := healthPolicy(&control.HealthPolicy{
+		Path:            "/healthz",
+		ExpectStatus:    "2xx",
+		IntervalSeconds: 1,
+		TimeoutSeconds:  30,
+		Threshold:       5,
+		Action:          health.ActionClose,
+	})
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if p.Path != "/healthz" || p.Expect.String() != "200-299" || p.Threshold != 5 || p.Action != health.ActionClose {
+		t.Fatalf("policy = %+v", p)
+	}
+	// The interval has a floor, and the timeout never exceeds it.
+	if p.Interval != minHealthInterval || p.Timeout != minHealthInterval {
+		t.Fatalf("interval %v, timeout %v, want both %v", p.Interval, p.Timeout, minHealthInterval)
+	}
+	if p, _, _ := healthPolicy(&control.HealthPolicy{IntervalSeconds: 60}); p.Interval != time.Minute {
+		t.Fatalf("interval = %v, want 1m", p.Interval)
+	}
+}
+
+func TestHealthPolicyInvalid(t *testing.T) {
+	for name, req := range map[string]control.HealthPolicy{
+		"relative path": {Path: "healthz"},
+		"bad status":    {ExpectStatus: "7xx"},
+		"bad action":    {Action: "restart"},
+	} {
+		if _, ok, err := healthPolicy(&req); err == nil || ok {
+			t.Errorf("%s: healthPolicy = %v, %v, want an error", name, ok, err)
+		}
+	}
+}
+
+func TestProbeClientSkipsQuota(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	s.Bandwidth.DailyQuota = 100
+	s.usage = newUsageTable(&s.Bandwidth)
+	ctl, client := net.Pipe()
+	defer ctl.Close()
+	defer client.Close()
+	info := &tunnelInfo{tunnel: &tunnel.Tunnel{ID: "t1"}, ctlConn: ctl, meter: s.newMeter("t1", "client")}
+	info.meter.client.add(&s.Bandwidth, 100)
+	s.tunnels["t1"] = info
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.dialClient(ctx, "t1"); !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("dialClient out of quota = %v, want errQuotaExceeded", err)
+	}
+
+	// Answer the probe's ConnRequest the way the client's data connection
+	// would arrive.
+	go func() {
+		var req control.ConnRequest
+		if control.ReadJSONLine(bufio.NewReader(client), &req) != nil {
+			return
+		}
+		server, data := net.Pipe()
+		defer data.Close()
+		go s.handleDataConn(server)
+		_ = control.WriteJSONLine(data, control.DataInit{Type: "data_init", TunnelID: req.TunnelID, ConnID: req.ConnID})
+		<-ctx.Done()
+	}()
+	conn, err := s.probeClient(ctx, "t1")
+	if err != nil {
+		t.Fatalf("probeClient out of quota = %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*meteredConn); ok {
+		t.Fatal("probe connection is metered")
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipFilter is a per-tunnel CIDR allow/deny list. Deny entries win; when the
+// allow list is non-empty only addresses matching it get through.
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func newIPFilter(allow, deny []string) (*ipFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f := &ipFilter{}
+	var err error
+	if f.allow, err = ParsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = ParsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ParsePrefixes parses CIDRs, accepting bare IPs as single-address prefixes.
+func ParsePrefixes(ss []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP or CIDR %q", s)
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR %q", s)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ipFilter) allowed(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	if containsAddr(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, ip)
+}
+
+// remoteAddr returns the IP of a connection's direct peer.
+func remoteAddr(addr net.Addr) netip.Addr {
+	if ta, ok := addr.(*net.TCPAddr); ok {
+		if ip, ok := netip.AddrFromSlice(ta.IP); ok {
+			return ip.Unmap()
+		}
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// visitorIP returns the originating IP of r. X-Forwarded-For is only
+// honored when the direct peer is a trusted proxy (Caddy on loopback by
+// default); the chain is walked right to left, skipping trusted hops, so a
+// visitor cannot spoof their address by sending the header themselves.
+func (s *Server) visitorIP(r *http.Request) netip.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	ip := ap.Addr().Unmap()
+	if !containsAddr(s.TrustedProxies, ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !containsAddr(s.TrustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// ipFilterHandler answers 403 to visitors outside the tunnel's lists.
+func (s *Server) ipFilterHandler(f *ipFilter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.allowed(s.visitorIP(r)) {
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// filterListener drops connections from untrusted peers outside the filter
+// before any bytes are read. Connections from trusted proxies are checked per
+// request by ipFilterHandler instead.
+type filterListener struct {
+	net.Listener
+	s *Server
+	f *ipFilter
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteAddr(conn.RemoteAddr())
+		if containsAddr(l.s.TrustedProxies, ip) || l.f.allowed(ip) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
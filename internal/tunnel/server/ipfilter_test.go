@@ -0,0 +1,146 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestVisitorIP(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	s.TrustedProxies = []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.1/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}
+	for name, tc := range map[string]struct {
+		remote string
+		xff    []string
+		want   string
+	}{
+		"direct visitor":  {remote: "198.51.100.7:5000", want: "198.51.100.7"},
+		"through a proxy": {remote: "127.0.0.1:5000", xff: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		// Only the hops the trusted proxies appended are believed: the
+		// leftmost entry is whatever the visitor claimed.
+		"spoofed chain": {remote: "127.0.0.1:5000", xff: []string{"203.0.113.1, 198.51.100.7"}, want: "198.51.100.7"},
+		"trusted hops":  {remote: "127.0.0.1:5000", xff: []string{"198.51.100.7, 10.1.2.3", "10.4.5.6"}, want: "198.51.100.7"},
+		"spoofed by an untrusted peer": {
+			remote: "198.51.100.7:5000", xff: []string{"203.0.113.1"}, want: "198.51.100.7",
+		},
+		"garbage hop": {remote: "127.0.0.1:5000", xff: []string{"nonsense, 198.51.100.7"}, want: "198.51.100.7"},
+		"ipv4-mapped": {remote: "[::ffff:198.51.100.7]:5000", want: "198.51.100.7"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := s.visitorIP(r); got.String() != tc.want {
+			t.Errorf("%s: visitorIP = %v, want %s", name, got, tc.want)
+		}
+	}
+}
+
+func TestIPFilterAllowed(t *testing.T) {
+	f, err := newIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.6.6.0/24", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":         true,
+		"10.6.6.6":         false, // deny wins over the wider allow
+		"192.0.2.1":        false, // outside the allow list
+		"2001:db8::2":      true,
+		"2001:db8::1":      false,
+		"::ffff:10.1.2.3":  true,
+		"::ffff:10.6.6.10": false,
+	} {
+		if got := f.allowed(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("allowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if f.allowed(netip.Addr{}) {
+		t.Error("an unknown address was allowed")
+	}
+
+	deny, err := newIPFilter(nil, []string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deny.allowed(netip.MustParseAddr("198.51.100.1")) || deny.allowed(netip.MustParseAddr("192.0.2.9")) {
+		t.Error("a deny-only filter should let everyone else through")
+	}
+	if f, err := newIPFilter(nil, nil); f != nil || err != nil {
+		t.Errorf("empty lists = %v, %v, want no filter", f, err)
+	}
+	if _, err := newIPFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
+
+func TestFilterListener(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	s.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.3/32")}
+	f, err := newIPFilter([]string{"127.0.0.2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl := &filterListener{Listener: ln, s: s, f: f}
+	defer fl.Close()
+	accepted := make(chan netip.Addr, 3)
+	go func() {
+		for {
+			conn, err := fl.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- remoteAddr(conn.RemoteAddr())
+			conn.Close()
+		}
+	}()
+
+	dial := func(from string) net.Conn {
+		t.Helper()
+		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(from)}}
+		conn, err := d.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Skipf("cannot dial from %s: %v", from, err)
+		}
+		return conn
+	}
+
+	// A peer outside the filter is closed before Accept returns it.
+	denied := dial("127.0.0.1")
+	defer denied.Close()
+	_ = denied.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := denied.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("denied peer read = %v, want EOF", err)
+	}
+
+	// Allowed peers and trusted proxies are handed on; the proxy's
+	// visitors are checked per request instead.
+	for _, from := range []string{"127.0.0.2", "127.0.0.3"} {
+		conn := dial(from)
+		select {
+		case ip := <-accepted:
+			if ip.String() != from {
+				t.Fatalf("accepted %v, want %s", ip, from)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection from %s was not accepted", from)
+		}
+		conn.Close()
+	}
+	select {
+	case ip := <-accepted:
+		t.Fatalf("also accepted %v", ip)
+	default:
+	}
+}
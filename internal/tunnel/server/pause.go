@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/offline"
+)
+
+// Maintenance is the response visitors of a paused tunnel get instead of
+// reaching the client. The zero value serves the built-in "paused" page,
+// with the client's message, as a 503.
+type Maintenance struct {
+	Status      int    // defaults to 503
+	Body        []byte // replaces the built-in page, e.g. a file's contents
+	ContentType string // of Body; defaults to text/html
+}
+
+// response returns what to send for a paused tunnel at host.
+func (m *Maintenance) response(host, message string) (status int, contentType string, body []byte) {
+	status, contentType, body = m.Status, m.ContentType, m.Body
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	if body == nil {
+		body = offline.PausedPage(host, message)
+	}
+	return status, contentType, body
+}
+
+// pauseMessage returns the message the tunnel's client paused it with, and
+// whether it is paused.
+func (t *tunnelInfo) pauseMessage() (string, bool) {
+	if msg := t.paused.Load(); msg != nil {
+		return *msg, true
+	}
+	return "", false
+}
+
+// pauseHandler answers visitors with the maintenance response while the
+// client has the tunnel paused.
+func (s *Server) pauseHandler(tunnelID string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		t := s.tunnels[tunnelID]
+		s.mu.RUnlock()
+		if t == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		msg, paused := t.pauseMessage()
+		if !paused {
+			next.ServeHTTP(w, r)
+			return
+		}
+		status, contentType, body := s.Maintenance.response(r.Host, msg)
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("Cache-Control", "no-store")
+		h.Set("Retry-After", strconv.Itoa(offline.RetryAfter))
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	})
+}
+
+// servePaused answers a raw visitor connection to a paused tunnel. HTTP
+// visitors get the maintenance response; other protocols are closed once
+// no request arrives.
+func (s *Server) servePaused(conn net.Conn, message string) {
+	defer conn.Close()
+	_ = conn.SetReadDeadline(deadline(min(s.Timeouts.Handshake, 5*time.Second)))
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	req.Body.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	status, contentType, body := s.Maintenance.response(req.Host, message)
+	_ = offline.WriteRaw(conn, status, contentType, body)
+}
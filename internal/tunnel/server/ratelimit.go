@@ -0,0 +1,188 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket holding up to Burst tokens and refilling one token
+// every Every. "5 per minute" is Limit{Every: 12 * time.Second, Burst: 5}.
+type Limit struct {
+	Every time.Duration
+	Burst int
+}
+
+// Per returns the limit allowing n events per d, all of which may burst.
+func Per(n int, d time.Duration) Limit {
+	return Limit{Every: d / time.Duration(n), Burst: n}
+}
+
+// ParseLimits parses a comma-separated list such as "5/1m,20/1h" into
+// limits allowing 5 per minute and 20 per hour. "" or "off" means unlimited.
+func ParseLimits(s string) ([]Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return nil, nil
+	}
+	var out []Limit
+	for _, part := range strings.Split(s, ",") {
+		ns, ds, ok := strings.Cut(strings.TrimSpace(part), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q (expected count/duration)", part)
+		}
+		n, err := strconv.Atoi(ns)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit count %q", ns)
+		}
+		d, err := time.ParseDuration(ds)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid limit duration %q", ds)
+		}
+		out = append(out, Per(n, d))
+	}
+	return out, nil
+}
+
+// RateLimits groups the server's limits. Each list applies in full: an event
+// is allowed only when every bucket in it has a token. Empty lists disable
+// that limit.
+type RateLimits struct {
+	ControlConnects []Limit // control connections per client IP, checked on accept
+	TunnelCreates   []Limit // tunnels opened per client IP and per client token
+	VisitorConns    []Limit // visitor connections (edge: requests) per tunnel per visitor IP
+}
+
+// DefaultRateLimits keeps the long-standing 5 tunnels per minute and 20 per
+// hour for each IP.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		ControlConnects: []Limit{Per(10, 20*time.Second)},
+		TunnelCreates:   []Limit{Per(5, time.Minute), Per(20, time.Hour)},
+		VisitorConns:    []Limit{Per(200, time.Second)},
+	}
+}
+
+// maxLimiterKeys bounds the per-key state kept by a RateLimiter
+const maxLimiterKeys = 100_000
+
+// RateLimiter is a set of token buckets keyed by IP, token or tunnel.
+// Keys are kept in least-recently-used order: keys idle long enough for
+// their buckets to refill completely carry no state and are dropped from the
+// tail as new keys arrive, and past maxLimiterKeys the least recently used
+// key is evicted, so memory stays bounded and no call scans every key.
+type RateLimiter struct {
+	limits []Limit
+	refill time.Duration // time for empty buckets to fill completely
+
+	mu   sync.Mutex
+	keys map[string]*list.Element // of *buckets
+	lru  *list.List               // most recently used first
+}
+
+type buckets struct {
+	key    string
+	tokens []float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter enforcing all of limits per key. With no
+// limits every event is allowed.
+func NewRateLimiter(limits ...Limit) *RateLimiter {
+	rl := &RateLimiter{
+		limits: limits,
+		keys:   make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+	for _, l := range limits {
+		rl.refill = max(rl.refill, l.Every*time.Duration(l.Burst))
+	}
+	return rl
+}
+
+// Allow takes a token for every key from each of its buckets. If any bucket
+// is empty nothing is taken and the wait until the event would be allowed is
+// returned.
+func (rl *RateLimiter) Allow(keys ...string) (bool, time.Duration) {
+	if rl == nil || len(rl.limits) == 0 {
+		return true, 0
+	}
+	now := time.Now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.expire(now)
+	bs := make([]*buckets, 0, len(keys))
+	var wait time.Duration
+	for _, key := range keys {
+		b := rl.get(key, now)
+		elapsed := now.Sub(b.last)
+		b.last = now
+		for i, l := range rl.limits {
+			b.tokens[i] = min(float64(l.Burst), b.tokens[i]+float64(elapsed)/float64(l.Every))
+			if b.tokens[i] < 1 {
+				wait = max(wait, time.Duration((1-b.tokens[i])*float64(l.Every)))
+			}
+		}
+		bs = append(bs, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range bs {
+		for i := range b.tokens {
+			b.tokens[i]--
+		}
+	}
+	return true, 0
+}
+
+// get returns the buckets for key, creating full ones if needed, and marks
+// the key as most recently used.
+func (rl *RateLimiter) get(key string, now time.Time) *buckets {
+	if e := rl.keys[key]; e != nil {
+		rl.lru.MoveToFront(e)
+		return e.Value.(*buckets)
+	}
+	if rl.lru.Len() >= maxLimiterKeys {
+		rl.remove(rl.lru.Back())
+	}
+	b := &buckets{key: key, tokens: make([]float64, len(rl.limits)), last: now}
+	for i, l := range rl.limits {
+		b.tokens[i] = float64(l.Burst)
+	}
+	rl.keys[key] = rl.lru.PushFront(b)
+	return b
+}
+
+// expire drops keys whose buckets would be full by now. They sit at the
+// tail, so only expired keys are visited.
+func (rl *RateLimiter) expire(now time.Time) {
+	for e := rl.lru.Back(); e != nil && now.Sub(e.Value.(*buckets).last) >= rl.refill; e = rl.lru.Back() {
+		rl.remove(e)
+	}
+}
+
+func (rl *RateLimiter) remove(e *list.Element) {
+	delete(rl.keys, e.Value.(*buckets).key)
+	rl.lru.Remove(e)
+}
+
+// limiterKey is the rate limit key for ip. IPv6 clients usually hold a whole
+// /64, so they are limited per /64 rather than per address.
+func limiterKey(ip netip.Addr) string {
+	if ip.Is6() {
+		p, _ := ip.Prefix(64)
+		return p.String()
+	}
+	return ip.String()
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for Retry-After.
+func retryAfterSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	rl := NewRateLimiter(Per(3, time.Hour))
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.Allow("a"); !ok {
+			t.Fatalf("event %d refused within burst", i)
+		}
+	}
+	ok, wait := rl.Allow("a")
+	if ok || wait <= 0 || wait > 20*time.Minute {
+		t.Fatalf("Allow after burst = %v, %v; want refused with a wait up to 20m", ok, wait)
+	}
+	if ok, _ := rl.Allow("b"); !ok {
+		t.Fatal("other key refused")
+	}
+}
+
+func TestRateLimiterAllKeysOrNone(t *testing.T) {
+	rl := NewRateLimiter(Per(1, time.Hour))
+	if ok, _ := rl.Allow("token"); !ok {
+		t.Fatal("first event refused")
+	}
+	// The token bucket is empty, so the IP must keep its token.
+	if ok, _ := rl.Allow("ip", "token"); ok {
+		t.Fatal("Allow succeeded with an empty bucket")
+	}
+	if ok, _ := rl.Allow("ip"); !ok {
+		t.Fatal("refused event spent the other key's token")
+	}
+}
+
+func TestRateLimiterExpiresIdleKeys(t *testing.T) {
+	rl := NewRateLimiter(Limit{Every: time.Millisecond, Burst: 2})
+	rl.Allow("a")
+	rl.Allow("b")
+	time.Sleep(5 * time.Millisecond)
+	rl.Allow("c")
+	if n := rl.lru.Len(); n != 1 || len(rl.keys) != 1 {
+		t.Fatalf("tracking %d keys (%d in map), want only c", n, len(rl.keys))
+	}
+}
+
+func TestRateLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	rl := NewRateLimiter(Per(1, time.Hour))
+	for i := 0; i < maxLimiterKeys; i++ {
+		rl.Allow(fmt.Sprint(i))
+	}
+	rl.Allow("0") // refused, but now the most recently used
+	rl.Allow("new")
+	if len(rl.keys) != maxLimiterKeys {
+		t.Fatalf("tracking %d keys, want %d", len(rl.keys), maxLimiterKeys)
+	}
+	if rl.keys["1"] != nil {
+		t.Error("least recently used key was not evicted")
+	}
+	if ok, _ := rl.Allow("0"); ok {
+		t.Error("recently used key lost its state")
+	}
+}
+
+func TestLimiterKey(t *testing.T) {
+	for ip, want := range map[string]string{
+		"203.0.113.7":          "203.0.113.7",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"2001:db8:1:2::ffff":   "2001:db8:1:2::/64",
+	} {
+		if got := limiterKey(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("limiterKey(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	got, err := ParseLimits("5/1m, 20/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Limit{{Every: 12 * time.Second, Burst: 5}, {Every: 3 * time.Minute, Burst: 20}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ParseLimits = %v, want %v", got, want)
+	}
+	if l, err := ParseLimits("off"); err != nil || l != nil {
+		t.Fatalf(`ParseLimits("off") = %v, %v`, l, err)
+	}
+	for _, bad := range []string{"5", "0/1m", "5/x", "5/-1s"} {
+		if _, err := ParseLimits(bad); err == nil {
+			t.Errorf("ParseLimits(%q) succeeded", bad)
+		}
+	}
+}
@@ -5,15 +5,22 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"net/netip"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/HeyRistaa/got/internal/logging"
+	"github.com/HeyRistaa/got/internal/oidc"
 	"github.com/HeyRistaa/got/internal/protocol/control"
+	"github.com/HeyRistaa/got/internal/tracing"
 	"github.com/HeyRistaa/got/internal/tunnel"
 )
 
@@ -22,6 +29,68 @@ type Server struct {
 	DataListen    string // host:port where SERVER accepts client data connections
 	PublicIP      string // public IP or hostname to advertise (e.g., Hetzner IP)
 
+	// TrustedProxies are peers (Caddy) whose X-Forwarded-For is believed
+	// when resolving visitor IPs. Defaults to loopback.
+	TrustedProxies []netip.Prefix
+
+	// OIDC, when set, lets tunnels opt into a login gate with this provider.
+	OIDC *oidc.Provider
+
+	// RateLimits is read when Serve starts. Defaults to DefaultRateLimits.
+	RateLimits RateLimits
+
+	// Bandwidth shaping and transfer quotas, read when Serve starts.
+	// Unlimited by default.
+	Bandwidth Bandwidth
+
+	// Concurrency caps simultaneous visitors per tunnel and per client.
+	// Unlimited by default.
+	Concurrency Concurrency
+
+	// Timeouts for handshakes, idle visitor connections and keep-alives.
+	// Defaults to DefaultTimeouts.
+	Timeouts Timeouts
+
+	// Bans are consulted for every control connection and visitor. Defaults
+	// to an empty in-memory list; see LoadBanList.
+	Bans *BanList
+
+	// Abuse configures automatic bans. Defaults to DefaultAbuseRules.
+	Abuse AbuseRules
+
+	// Logger receives the server's records; nil logs to slog.Default().
+	Logger *slog.Logger
+
+	// Webhooks receive tunnel events, read when Serve starts. No endpoints
+	// by default.
+	Webhooks Webhooks
+
+	// Audit, when set, records tunnel lifecycle, auth failures, rate-limit
+	// denials and admin actions. See OpenAuditLog.
+	Audit *AuditLog
+
+	// Tracer, when set, records spans for each visitor connection, or each
+	// request through the HTTP edge, and passes trace context to the client.
+	Tracer *tracing.Tracer
+
+	// TraceInject sets a traceparent header on requests proxied through the
+	// HTTP edge so the local app can continue the trace. It needs Tracer;
+	// raw TCP tunnels only get the visitor span.
+	TraceInject bool
+
+	// StatsInterval is how often clients are sent their tunnel's traffic
+	// counters while they change; zero disables it. Defaults to 2s.
+	StatsInterval time.Duration
+
+	// Maintenance is what visitors of a paused tunnel get. Defaults to a
+	// 503 "paused" page.
+	Maintenance Maintenance
+
+	// AccessLog records every visitor request to tunnels served through the
+	// HTTP edge. Off by default.
+	AccessLog AccessLog
+	accessMu  sync.Mutex // serializes AccessLog writes
+
 	mu      sync.RWMutex
 	tunnels map[string]*tunnelInfo // by tunnelID
 	ports   map[int]string         // public port -> tunnelID
@@ -30,25 +99,87 @@ type Server struct {
 	pending   map[string]chan net.Conn // connID -> ready data conn from client
 
 	tunnelManager *tunnel.Manager
-	rateLimiter   *RateLimiter
+	open          atomic.Int64 // tunnels not yet cleaned up
+
+	// Token buckets built from RateLimits
+	controlLimiter *RateLimiter // keyed by client IP
+	createLimiter  *RateLimiter // keyed by "ip:<addr>" and "token:<token>"
+	visitorLimiter *RateLimiter // keyed by "<tunnelID>|<visitor IP>"
+
+	usage *usageTable // per-client bandwidth and quota state
+
+	clientSlots clientSlots    // per-client visitor semaphores
+	rejected    rejectCounters // visitors turned away by Concurrency
+
+	abuse *abuseDetector
+
+	webhooks *webhookSender // nil without Webhooks.Endpoints
 }
 
 type tunnelInfo struct {
 	tunnel  *tunnel.Tunnel
 	ctlConn net.Conn
+	edge    *http.Server // set when the tunnel is served through the HTTP edge
+	meter   *meter       // set when bandwidth shaping or quotas are enabled
+	gate    *visitorGate // set when concurrent visitors are limited
+
+	clientKey string // see clientKey
+	clientIP  string
+	clientID  string
+	token     string
+	opened    time.Time
+
+	stop  context.CancelFunc // ends the health check and stats loops
+	stats *trafficStats
+
+	log *slog.Logger // server logger with the tunnel's fields
+
+	closeMu     sync.Mutex
+	closeReason string // why the server closed the tunnel, see close
+
+	// upstreamDown is set while the client reports its local app as not
+	// accepting connections.
+	upstreamDown atomic.Bool
+
+	// paused holds the pause_tunnel message while the client has the tunnel
+	// paused, and is nil while visitors are forwarded.
+	paused atomic.Pointer[string]
+
+	// flagged holds why the tunnel was flagged for abuse review, and
+	// abuseReports how often it was reported since.
+	flagged      atomic.Pointer[string]
+	abuseReports atomic.Int64
 }
 
 func New(controlAddr, dataAddr, publicIP string) *Server {
-	return &Server{
+	return NewWithManager(controlAddr, dataAddr, publicIP, tunnel.NewManager())
+}
+
+// NewWithManager creates a server using the given tunnel manager, e.g. one
+// with a custom route provider.
+func NewWithManager(controlAddr, dataAddr, publicIP string, m *tunnel.Manager) *Server {
+	s := &Server{
 		ControlListen: controlAddr,
 		DataListen:    dataAddr,
 		PublicIP:      publicIP,
+		TrustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("127.0.0.0/8"),
+			netip.MustParsePrefix("::1/128"),
+		},
 		tunnels:       make(map[string]*tunnelInfo),
 		ports:         make(map[int]string),
 		pending:       make(map[string]chan net.Conn),
-		tunnelManager: tunnel.NewManager(),
-		rateLimiter:   NewRateLimiter(5, 20), // 5 per minute, 20 per hour
+		tunnelManager: m,
+		RateLimits:    DefaultRateLimits(),
+		Concurrency:   DefaultConcurrency(),
+		Timeouts:      DefaultTimeouts(),
+		Bans:          &BanList{},
+		Abuse:         DefaultAbuseRules(),
+		Webhooks:      DefaultWebhooks(),
+		StatsInterval: 2 * time.Second,
 	}
+	s.abuse = newAbuseDetector(s)
+	return s
 }
 
 func (s *Server) Run(ctx context.Context) error {
@@ -57,16 +188,42 @@ func (s *Server) Run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("listen control: %w", err)
 	}
-	defer ctlLn.Close()
 
 	// listener for client data connections
 	dataLn, err := net.Listen("tcp", s.DataListen)
 	if err != nil {
+		ctlLn.Close()
 		return fmt.Errorf("listen data: %w", err)
 	}
+
+	return s.Serve(ctx, ctlLn, dataLn)
+}
+
+// Serve accepts control and data connections on the given listeners until
+// ctx is done, then closes them along with every open tunnel.
+func (s *Server) Serve(ctx context.Context, ctlLn, dataLn net.Listener) error {
+	defer ctlLn.Close()
 	defer dataLn.Close()
+	ctlLn, dataLn = s.withKeepAlive(ctlLn), s.withKeepAlive(dataLn)
+
+	s.controlLimiter = NewRateLimiter(s.RateLimits.ControlConnects...)
+	s.createLimiter = NewRateLimiter(s.RateLimits.TunnelCreates...)
+	s.visitorLimiter = NewRateLimiter(s.RateLimits.VisitorConns...)
 
-	log.Printf("server: control %s, data %s, public IP %s", s.ControlListen, s.DataListen, s.PublicIP)
+	s.usage = newUsageTable(&s.Bandwidth)
+	if s.Bandwidth.UsageFile != "" {
+		if err := s.usage.load(s.Bandwidth.UsageFile); err != nil {
+			s.logger().Error("load usage", logging.Err(err))
+		}
+		go s.saveUsageLoop(ctx)
+		defer s.saveUsage()
+	}
+
+	if len(s.Webhooks.Endpoints) > 0 {
+		s.webhooks = newWebhookSender(s)
+	}
+
+	s.logger().Info("server listening", "control", ctlLn.Addr().String(), "data", dataLn.Addr().String(), "public_ip", s.PublicIP)
 
 	// Accept control connections and handle in goroutines
 	go func() {
@@ -76,14 +233,24 @@ func (s *Server) Run(ctx context.Context) error {
 				if errors.Is(err, net.ErrClosed) {
 					return
 				}
-				log.Printf("accept control: %v", err)
+				s.logger().Error("accept control", logging.Err(err))
 				continue
 			}
-			// Check rate limit before processing
-			clientIP := conn.RemoteAddr().(*net.TCPAddr).IP.String()
-			if !s.rateLimiter.Allow(clientIP) {
-				log.Printf("Rate limit exceeded for IP %s, closing connection", clientIP)
-				conn.Close()
+			ip := remoteAddr(conn.RemoteAddr())
+			if b := s.Bans.matchIP(ip); b != nil {
+				go rejectControl(conn, banMessage(b), b.until())
+				continue
+			}
+			// Check rate limit before processing. Recording the violation
+			// writes to disk and may ban, so it stays off the accept loop.
+			if ok, wait := s.controlLimiter.Allow(limiterKey(ip)); !ok {
+				go func() {
+					clientIP := ip.String()
+					s.logger().Warn("control connection rate limited", logging.RemoteIP, clientIP)
+					s.Audit.Record(AuditEvent{Type: AuditRateLimit, ClientIP: clientIP, Action: "control_connect"})
+					s.abuse.record(signalRateLimit, ip)
+					rejectControl(conn, "too many connections", wait)
+				}()
 				continue
 			}
 			go s.handleControl(conn)
@@ -98,7 +265,7 @@ func (s *Server) Run(ctx context.Context) error {
 				if errors.Is(err, net.ErrClosed) {
 					return
 				}
-				log.Printf("accept data: %v", err)
+				s.logger().Error("accept data", logging.Err(err))
 				continue
 			}
 			go s.handleDataConn(conn)
@@ -107,33 +274,108 @@ func (s *Server) Run(ctx context.Context) error {
 
 	// Block until context done
 	<-ctx.Done()
+	s.closeAll()
+	s.waitTunnels(5 * time.Second)
+	s.webhooks.shutdown(5 * time.Second)
 	return nil
 }
 
+// waitTunnels waits up to timeout for every tunnel to be cleaned up, so
+// routes are removed and closes audited before Serve returns.
+func (s *Server) waitTunnels(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for s.open.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// closeAll drops every control connection; each handleControl loop then
+// cleans up its own tunnel.
+func (s *Server) closeAll() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tunnels {
+		t.close(CloseShutdown)
+	}
+}
+
 func (s *Server) handleControl(conn net.Conn) {
 	defer conn.Close()
 	r := bufio.NewReader(conn)
 
-	// Extract client IP for rate limiting
-	clientIP := conn.RemoteAddr().(*net.TCPAddr).IP.String()
-	if !s.rateLimiter.Allow(clientIP) {
-		log.Printf("Rate limit exceeded for IP %s", clientIP)
-		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: "rate limit exceeded"})
-		return
-	}
+	clientIP := remoteAddr(conn.RemoteAddr()).String()
+	log := s.logger().With(logging.RemoteIP, clientIP)
 
+	// The client has to ask for its tunnel promptly
+	_ = conn.SetReadDeadline(deadline(s.Timeouts.Handshake))
 	var req control.OpenTunnel
 	if err := control.ReadJSONLine(r, &req); err != nil {
-		log.Printf("control: read open_tunnel: %v", err)
+		log.Warn("read open_tunnel", logging.Err(err))
 		return
 	}
+	log = log.With(logging.ClientID, req.ClientID)
 	if req.Type != "open_tunnel" {
-		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: "expected open_tunnel"})
+		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: "expected open_tunnel", Permanent: true})
 		return
 	}
 
-	log.Printf("Received open_tunnel request from client %s (IP: %s) for local %s", req.ClientID, clientIP, req.LocalHint)
-	log.Printf("Current active tunnels: %d", len(s.tunnels))
+	if b := s.Bans.matchClient(req.ClientID, req.Token); b != nil {
+		log.Info("refused banned client", "ban", b.Kind)
+		_ = control.WriteJSONLine(conn, control.TunnelError{
+			Type:       "tunnel_error",
+			Error:      banMessage(b),
+			RetryAfter: retryAfterSeconds(b.until()),
+			Permanent:  b.Expires.IsZero(),
+		})
+		return
+	}
+
+	// Tunnel creation is limited per IP and, when one is presented, per token
+	if ok, wait := s.allowCreate(remoteAddr(conn.RemoteAddr()), req.Token); !ok {
+		log.Warn("tunnel creation rate limited")
+		s.Audit.Record(AuditEvent{Type: AuditRateLimit, ClientIP: clientIP, ClientID: req.ClientID, Token: tokenKey(req.Token), Action: "tunnel_create"})
+		s.abuse.record(signalRateLimit, remoteAddr(conn.RemoteAddr()))
+		_ = control.WriteJSONLine(conn, control.TunnelError{
+			Type:       "tunnel_error",
+			Error:      "rate limit exceeded",
+			RetryAfter: retryAfterSeconds(wait),
+		})
+		return
+	}
+	if a := req.BasicAuth; a != nil && (a.Username == "" || strings.Contains(a.Username, ":")) {
+		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: "invalid basic auth username", Permanent: true})
+		return
+	}
+	if req.OIDC != nil && s.OIDC == nil {
+		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: "login gate requested but server has no OIDC provider", Permanent: true})
+		return
+	}
+	filter, err := newIPFilter(req.AllowCIDRs, req.DenyCIDRs)
+	if err != nil {
+		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: err.Error(), Permanent: true})
+		return
+	}
+	healthPol, healthOn, err := healthPolicy(req.Health)
+	if err != nil {
+		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: err.Error(), Permanent: true})
+		return
+	}
+	key := clientKey(req.Token, clientIP)
+	if s.Bandwidth.enabled() && s.Bandwidth.quotaAction() == QuotaClose {
+		if q := s.usage.get(key).exceeded(&s.Bandwidth); q != nil {
+			_ = control.WriteJSONLine(conn, control.TunnelError{
+				Type:       "tunnel_error",
+				Error:      q.period + " transfer quota exceeded",
+				RetryAfter: retryAfterSeconds(time.Until(q.resetAt)),
+			})
+			return
+		}
+	}
+
+	s.mu.RLock()
+	active := len(s.tunnels)
+	s.mu.RUnlock()
+	log.Info("open_tunnel request", "local", req.LocalHint, "active_tunnels", active)
 
 	// Create tunnel using tunnel manager
 	domain := req.Domain
@@ -141,26 +383,42 @@ func (s *Server) handleControl(conn net.Conn) {
 		domain = "*.showapps.online" // default
 	}
 
-	log.Printf("Creating tunnel for client %s with domain %s", req.ClientID, domain)
 	tunnel, err := s.tunnelManager.CreateTunnel(req.ClientID, domain)
 	if err != nil {
-		log.Printf("Failed to create tunnel for client %s: %v", req.ClientID, err)
+		log.Error("create tunnel", "domain", domain, logging.Err(err))
 		_ = control.WriteJSONLine(conn, control.TunnelError{Type: "tunnel_error", Error: err.Error()})
 		return
 	}
-	log.Printf("Successfully created tunnel %s for client %s", tunnel.ID, req.ClientID)
 
 	// Store tunnel info
 	tid := tunnel.ID
+	log = log.With(logging.TunnelID, tid)
 	s.mu.Lock()
-	s.tunnels[tid] = &tunnelInfo{
-		tunnel:  tunnel,
-		ctlConn: conn,
+	info := &tunnelInfo{
+		tunnel:    tunnel,
+		ctlConn:   conn,
+		gate:      s.newVisitorGate(key),
+		clientKey: key,
+		clientIP:  clientIP,
+		clientID:  req.ClientID,
+		token:     req.Token,
+		opened:    time.Now(),
+		log:       log,
+		stats:     &trafficStats{},
+	}
+	tunnelCtx, stop := context.WithCancel(context.Background())
+	info.stop = stop
+	if s.Bandwidth.enabled() {
+		info.meter = s.newMeter(tid, key)
 	}
+	s.tunnels[tid] = info
+	s.open.Add(1)
 	s.ports[tunnel.Port] = tid
 	s.mu.Unlock()
 
-	log.Printf("Stored tunnel %s (port %d) for client %s", tid, tunnel.Port, req.ClientID)
+	log.Info("tunnel created", "host", tunnel.Host, "port", tunnel.Port)
+	s.auditTunnel(info, AuditTunnelOpen, "")
+	s.notifyTunnel(info, WebhookTunnelOpened, "", "")
 
 	// Send tunnel opened response
 	pub := fmt.Sprintf("%s:%d", s.PublicIP, tunnel.Port)
@@ -171,86 +429,305 @@ func (s *Server) handleControl(conn net.Conn) {
 		PublicHost: tunnel.Host,
 	}
 	if err := control.WriteJSONLine(conn, opened); err != nil {
-		log.Printf("control: write opened: %v", err)
+		log.Warn("write tunnel_opened", logging.Err(err))
+		s.cleanupTunnel(tid, tunnel.Port, tunnel.Listener)
 		return
 	}
+	if info.meter != nil {
+		// A paused client reopening its tunnel is told straight away
+		if q := info.meter.exceeded(); q != nil {
+			info.meter.quotaExceeded(q)
+		}
+	}
 
 	// Start serving public connections
-	log.Printf("Starting public listener for tunnel %s on port %d", tid, tunnel.Port)
-	go s.servePublic(tunnel.Listener, tid, tunnel.Port)
+	if s.needsEdge(&req) {
+		log.Debug("serving public listener through the HTTP edge")
+		go s.serveEdge(tunnel.Listener, tid, tunnel.Port, &req, filter, info.gate)
+	} else {
+		go s.servePublic(tunnel.Listener, tid, tunnel.Port, info.gate)
+	}
 
 	// Start health checking
-	log.Printf("Starting health check for tunnel %s", tid)
-	s.tunnelManager.StartHealthCheck(tunnel, func() {
-		log.Printf("Health check cleanup triggered for tunnel %s", tid)
-		s.cleanupTunnel(tid, tunnel.Port, tunnel.Listener)
-	})
+	if healthOn {
+		log.Debug("starting health check", "path", healthPol.Path, "interval", healthPol.Interval)
+		s.startHealthCheck(tunnelCtx, info, healthPol)
+	}
+	if s.StatsInterval > 0 {
+		go s.pushStats(tunnelCtx, info)
+	}
 
-	// Keep control connection open until client disconnects
-	log.Printf("Control connection established for tunnel %s, waiting for client disconnect", tid)
+	// Read client messages until it disconnects
 	_ = conn.SetReadDeadline(time.Time{})
 	for {
-		if _, err := r.Peek(1); err != nil {
+		var msg json.RawMessage
+		if err := control.ReadJSONLine(r, &msg); err != nil {
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				continue
+			}
 			// client closed
-			log.Printf("Client disconnected for tunnel %s, cleaning up", tid)
+			log.Info("client disconnected")
 			s.cleanupTunnel(tid, tunnel.Port, tunnel.Listener)
 			return
 		}
-		time.Sleep(5 * time.Second)
+		s.clientMessage(info, msg)
+	}
+}
+
+// clientMessage handles a message sent by the client after its tunnel opened.
+func (s *Server) clientMessage(t *tunnelInfo, msg json.RawMessage) {
+	var hdr struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(msg, &hdr)
+	switch hdr.Type {
+	case "heartbeat":
+		var hb control.Heartbeat
+		if json.Unmarshal(msg, &hb) == nil {
+			_ = control.WriteJSONLine(t.ctlConn, hb)
+		}
+	case "pause_tunnel":
+		var p control.PauseTunnel
+		if json.Unmarshal(msg, &p) == nil {
+			t.paused.Store(&p.Message)
+			t.log.Info("tunnel paused", "message", p.Message)
+		}
+	case "resume_tunnel":
+		if t.paused.Swap(nil) != nil {
+			t.log.Info("tunnel resumed")
+		}
+	case "upstream_status":
+		var us control.UpstreamStatus
+		if json.Unmarshal(msg, &us) != nil || t.upstreamDown.Load() == !us.Up {
+			return
+		}
+		t.upstreamDown.Store(!us.Up)
+		if us.Up {
+			t.log.Info("local app is back")
+		} else {
+			t.log.Warn("local app is down", "error", us.Error)
+		}
+	}
+}
+
+func (s *Server) logger() *slog.Logger { return logging.Or(s.Logger) }
+
+// close drops the tunnel's control connection, which makes handleControl
+// clean it up, and records reason for the audit log. The first reason wins.
+func (t *tunnelInfo) close(reason string) {
+	t.closeMu.Lock()
+	if t.closeReason == "" {
+		t.closeReason = reason
 	}
+	t.closeMu.Unlock()
+	t.ctlConn.Close()
+}
+
+// reason is why the tunnel closed: the reason given to close, or a client
+// disconnect.
+func (t *tunnelInfo) reason() string {
+	t.closeMu.Lock()
+	defer t.closeMu.Unlock()
+	if t.closeReason == "" {
+		return CloseClientDisconnect
+	}
+	return t.closeReason
+}
+
+// allowCreate takes a tunnel creation token for ip and, when presented, for
+// token; neither is spent unless both buckets allow it.
+func (s *Server) allowCreate(ip netip.Addr, token string) (bool, time.Duration) {
+	if token == "" {
+		return s.createLimiter.Allow("ip:" + limiterKey(ip))
+	}
+	return s.createLimiter.Allow("ip:"+limiterKey(ip), "token:"+token)
+}
+
+// banMessage is the tunnel_error sent to a banned client.
+func banMessage(b *Ban) string {
+	if b.Reason == "" {
+		return "banned"
+	}
+	return "banned: " + b.Reason
+}
+
+// rejectControl tells a rate-limited client when to retry and hangs up.
+func rejectControl(conn net.Conn, msg string, wait time.Duration) {
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_ = control.WriteJSONLine(conn, control.TunnelError{
+		Type:       "tunnel_error",
+		Error:      msg,
+		RetryAfter: retryAfterSeconds(wait),
+	})
 }
 
-func (s *Server) servePublic(ln net.Listener, tunnelID string, port int) {
+// servePublic bridges each visitor connection on ln to the client. With a
+// gate, visitors beyond the tunnel's limit wait in its queue and are closed
+// once the queue is full or they time out, so goroutines and ConnRequests
+// stay bounded.
+func (s *Server) servePublic(ln net.Listener, tunnelID string, port int, gate *visitorGate) {
 	defer ln.Close()
+	ln = s.withKeepAlive(ln)
 	for {
 		userConn, err := ln.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			log.Printf("public accept [%d]: %v", port, err)
+			s.logger().Error("public accept", logging.TunnelID, tunnelID, "port", port, logging.Err(err))
+			continue
+		}
+		visitor := remoteAddr(userConn.RemoteAddr())
+		// Behind Caddy the peer is the proxy, shared by every visitor, so IP
+		// bans and the per-visitor limit only apply to direct visitors here.
+		// The edge applies both per request using X-Forwarded-For.
+		if !containsAddr(s.TrustedProxies, visitor) {
+			if s.Bans.matchIP(visitor) != nil {
+				userConn.Close()
+				continue
+			}
+			if ok, _ := s.visitorLimiter.Allow(tunnelID + "|" + limiterKey(visitor)); !ok {
+				userConn.Close()
+				continue
+			}
+		}
+		if gate == nil {
+			go s.bridgeUserConnection(tunnelID, userConn)
+			continue
+		}
+		if release, ok := gate.tryAcquire(); ok {
+			go func() {
+				defer release()
+				s.bridgeUserConnection(tunnelID, userConn)
+			}()
 			continue
 		}
-		go s.bridgeUserConnection(tunnelID, userConn)
+		if err := gate.enqueue(); err != nil {
+			userConn.Close()
+			continue
+		}
+		go func() {
+			release, err := gate.wait(context.Background())
+			if err != nil {
+				userConn.Close()
+				return
+			}
+			defer release()
+			s.bridgeUserConnection(tunnelID, userConn)
+		}()
 	}
 }
 
 func (s *Server) bridgeUserConnection(tunnelID string, userConn net.Conn) {
-	// Ask client to open a data connection back to server
+	span := s.Tracer.Start("visitor", tracing.KindServer, tracing.SpanContext{})
+	defer span.End()
+	span.SetAttr("got.tunnel_id", tunnelID)
+	span.SetAttr("client.address", remoteAddr(userConn.RemoteAddr()).String())
+
 	s.mu.RLock()
 	t := s.tunnels[tunnelID]
 	s.mu.RUnlock()
-	if t == nil || t.ctlConn == nil {
-		log.Printf("no control conn for tunnel %s", tunnelID)
+	var st *trafficStats
+	if t != nil {
+		st = t.stats
+	}
+	st.visitor(remoteAddr(userConn.RemoteAddr()).String())
+	userConn = st.track(userConn)
+	if t != nil {
+		if msg, paused := t.pauseMessage(); paused {
+			span.SetAttr("got.paused", true)
+			s.servePaused(userConn, msg)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(tracing.ContextWith(context.Background(), span), 10*time.Second)
+	defer cancel()
+	dataConn, err := s.dialClient(ctx, tunnelID)
+	if err != nil {
+		st.fail()
+		span.RecordError(err)
+		s.logger().Warn("bridge visitor", logging.TunnelID, tunnelID, logging.RemoteIP, remoteAddr(userConn.RemoteAddr()).String(), logging.Err(err))
 		userConn.Close()
 		return
 	}
+	pipeConns(userConn, dataConn, s.Timeouts)
+}
 
+// dialClient asks the client behind tunnelID to open a data connection back
+// to the server and waits for it to arrive on the data listener. The round
+// trip is traced as a child of the span in ctx.
+func (s *Server) dialClient(ctx context.Context, tunnelID string) (conn net.Conn, err error) {
+	span := s.Tracer.Start("conn_request", tracing.KindClient, tracing.FromContext(ctx).Context())
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttr("got.tunnel_id", tunnelID)
+
+	t, err := s.clientTunnel(tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	if t.meter != nil && t.meter.exceeded() != nil {
+		return nil, errQuotaExceeded
+	}
 	connID := randomID()
+	span.SetAttr("got.conn_id", connID)
+	dataConn, err := s.requestConn(ctx, t, connID, span.Traceparent())
+	if err != nil {
+		return nil, err
+	}
+	if t.meter != nil {
+		return &meteredConn{Conn: dataConn, m: t.meter}, nil
+	}
+	return dataConn, nil
+}
+
+// probeClient is dialClient for health checks. Probes are not visitor
+// traffic, so they are neither traced nor metered against quotas.
+func (s *Server) probeClient(ctx context.Context, tunnelID string) (net.Conn, error) {
+	t, err := s.clientTunnel(tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	return s.requestConn(ctx, t, randomID(), "")
+}
+
+func (s *Server) clientTunnel(tunnelID string) (*tunnelInfo, error) {
+	s.mu.RLock()
+	t := s.tunnels[tunnelID]
+	s.mu.RUnlock()
+	if t == nil || t.ctlConn == nil {
+		return nil, fmt.Errorf("no control conn for tunnel %s", tunnelID)
+	}
+	return t, nil
+}
+
+// requestConn sends t's client a ConnRequest for connID and waits for the
+// data connection.
+func (s *Server) requestConn(ctx context.Context, t *tunnelInfo, connID, traceparent string) (net.Conn, error) {
 	ch := make(chan net.Conn, 1)
 	s.pendingMu.Lock()
 	s.pending[connID] = ch
 	s.pendingMu.Unlock()
+	defer s.clearPending(connID)
 
-	if err := control.WriteJSONLine(t.ctlConn, control.ConnRequest{Type: "conn_request", TunnelID: tunnelID, ConnID: connID}); err != nil {
-		log.Printf("write conn_request: %v", err)
-		userConn.Close()
-		s.clearPending(connID)
-		return
+	if err := control.WriteJSONLine(t.ctlConn, control.ConnRequest{Type: "conn_request", TunnelID: t.tunnel.ID, ConnID: connID, Traceparent: traceparent}); err != nil {
+		return nil, fmt.Errorf("write conn_request: %w", err)
 	}
 
 	select {
 	case dataConn := <-ch:
-		pipeConns(userConn, dataConn)
-	case <-time.After(10 * time.Second):
-		log.Printf("timeout waiting for client data conn for %s", connID)
-		userConn.Close()
+		return dataConn, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timeout waiting for client data conn for %s: %w", connID, ctx.Err())
 	}
-	s.clearPending(connID)
 }
 
 func (s *Server) cleanupTunnel(tunnelID string, port int, ln net.Listener) {
-	log.Printf("Cleaning up tunnel %s (port %d)", tunnelID, port)
 	ln.Close()
 	s.mu.Lock()
 	t := s.tunnels[tunnelID]
@@ -258,32 +735,72 @@ func (s *Server) cleanupTunnel(tunnelID string, port int, ln net.Listener) {
 	delete(s.ports, port)
 	s.mu.Unlock()
 
+	if t != nil && t.edge != nil {
+		t.edge.Close()
+	}
+	if t != nil && t.stop != nil {
+		t.stop()
+	}
+	if t != nil && t.meter != nil {
+		t.meter.close()
+	}
+	if t != nil && s.Abuse.ShortLived > 0 && time.Since(t.opened) < s.Abuse.ShortLived {
+		if ip, err := netip.ParseAddr(t.clientIP); err == nil {
+			s.abuse.record(signalShortLived, ip)
+		}
+	}
+	if t != nil && t.gate != nil {
+		if t.gate.client != nil {
+			s.clientSlots.put(t.clientKey)
+		}
+		if r := t.gate.rejected.stats(); r != (RejectStats{}) {
+			t.log.Warn("visitors turned away", "queue_full", r.QueueFull, "timed_out", r.Timeout)
+		}
+	}
+
 	// Close tunnel using tunnel manager
 	if t != nil && t.tunnel != nil {
-		log.Printf("Closing tunnel %s via tunnel manager", tunnelID)
 		if err := s.tunnelManager.CloseTunnel(t.tunnel); err != nil {
-			log.Printf("failed to close tunnel: %v", err)
+			t.log.Error("close tunnel", logging.Err(err))
 		}
 	}
-	log.Printf("Tunnel %s cleanup completed", tunnelID)
+	if t != nil {
+		defer s.open.Add(-1)
+		reason := t.reason()
+		t.log.Info("tunnel closed", "port", port, "duration", time.Since(t.opened).Round(time.Second), "reason", reason)
+		s.auditTunnel(t, AuditTunnelClose, reason)
+		s.notifyTunnel(t, WebhookTunnelClosed, reason, "")
+	}
 }
 
 func (s *Server) handleDataConn(conn net.Conn) {
 	r := bufio.NewReader(conn)
 	var init control.DataInit
+	_ = conn.SetReadDeadline(deadline(s.Timeouts.Handshake))
 	if err := control.ReadJSONLine(r, &init); err != nil {
-		log.Printf("data init read: %v", err)
+		s.logger().Warn("read data_init", logging.RemoteIP, remoteAddr(conn.RemoteAddr()).String(), logging.Err(err))
 		conn.Close()
 		return
 	}
+	_ = conn.SetReadDeadline(time.Time{})
 	if init.Type != "data_init" {
 		conn.Close()
 		return
 	}
+	// The client's dial span is the parent; without it there is no trace to
+	// join.
+	var span *tracing.Span
+	if parent, ok := tracing.ParseTraceparent(init.Traceparent); ok {
+		span = s.Tracer.Start("data_init", tracing.KindServer, parent)
+		span.SetAttr("got.tunnel_id", init.TunnelID)
+		span.SetAttr("got.conn_id", init.ConnID)
+		defer span.End()
+	}
 	s.pendingMu.Lock()
 	ch := s.pending[init.ConnID]
 	s.pendingMu.Unlock()
 	if ch == nil {
+		span.RecordError(errors.New("no pending conn_request"))
 		conn.Close()
 		return
 	}
@@ -303,11 +820,14 @@ func randomID() string {
 	return hex.EncodeToString(b)
 }
 
-func pipeConns(a, b net.Conn) {
+// pipeConns copies between a and b until both directions are done, applying
+// the idle and max lifetime timeouts in t.
+func pipeConns(a, b net.Conn, t Timeouts) {
+	d := newPipeDeadlines(t)
 	var wg sync.WaitGroup
 	copy := func(dst, src net.Conn) {
 		defer wg.Done()
-		_, _ = ioCopy(dst, src)
+		_, _ = ioCopy(dst, src, d)
 		dst.Close()
 	}
 	wg.Add(2)
@@ -317,16 +837,25 @@ func pipeConns(a, b net.Conn) {
 }
 
 // ioCopy is a thin wrapper to allow deadline tweaks later.
-func ioCopy(dst net.Conn, src net.Conn) (int64, error) {
-	return netCopy(dst, src)
+func ioCopy(dst net.Conn, src net.Conn, d *pipeDeadlines) (int64, error) {
+	return netCopy(dst, src, d)
 }
 
-// netCopy mirrors io.Copy but keeps types explicit for future tuning.
-func netCopy(dst net.Conn, src net.Conn) (written int64, err error) {
+// netCopy mirrors io.Copy but keeps types explicit for future tuning. With
+// d, reads and writes carry deadlines and a read timing out only ends the
+// copy if the other direction has been quiet too.
+func netCopy(dst net.Conn, src net.Conn, d *pipeDeadlines) (written int64, err error) {
 	buf := make([]byte, 32*1024)
 	for {
+		if d != nil {
+			_ = src.SetReadDeadline(d.next())
+		}
 		nr, er := src.Read(buf)
 		if nr > 0 {
+			if d != nil {
+				d.touch()
+				_ = dst.SetWriteDeadline(d.next())
+			}
 			nw, ew := dst.Write(buf[0:nr])
 			if nw > 0 {
 				written += int64(nw)
@@ -341,6 +870,9 @@ func netCopy(dst net.Conn, src net.Conn) (written int64, err error) {
 			}
 		}
 		if er != nil {
+			if d != nil && !d.expired(er) {
+				continue
+			}
 			if errors.Is(er, net.ErrClosed) {
 				return written, nil
 			}
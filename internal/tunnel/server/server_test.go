@@ -0,0 +1,184 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeyRistaa/got/gottest"
+	"github.com/HeyRistaa/got/internal/tracing"
+	"github.com/HeyRistaa/got/internal/tunnel/server"
+	"github.com/HeyRistaa/got/tunnel"
+)
+
+// openTunnel starts a server, set up by configure, and a tunnel serving h
+// on it, closed at the end of the test.
+func openTunnel(t *testing.T, h http.Handler, configure ...func(*server.Server)) (*gottest.Server, *gottest.Tunnel) {
+	t.Helper()
+	srv, err := gottest.NewServer(configure...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	tun, err := srv.Open(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tun.Close() })
+	return srv, tun
+}
+
+// get fetches url on a fresh connection and returns the status and body,
+// or status 0 when the connection fails.
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	hc := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	resp, err := hc.Get(url)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+var hello = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.WriteString(w, "hello")
+})
+
+func TestVisitorLimitDirect(t *testing.T) {
+	_, tun := openTunnel(t, hello, func(s *server.Server) {
+		s.RateLimits.VisitorConns = []server.Limit{server.Per(1, time.Hour)}
+		s.TrustedProxies = nil
+	})
+	if code, body := get(t, tun.URL); code != http.StatusOK {
+		t.Fatalf("first visit = %d %s", code, body)
+	}
+	if code, _ := get(t, tun.URL); code != 0 {
+		t.Fatalf("second visit = %d, want the connection refused", code)
+	}
+}
+
+func TestVisitorLimitSkipsTrustedProxy(t *testing.T) {
+	// Loopback is a trusted proxy by default: its connections carry many
+	// visitors, so one of them must not use up everyone's bucket.
+	_, tun := openTunnel(t, hello, func(s *server.Server) {
+		s.RateLimits.VisitorConns = []server.Limit{server.Per(1, time.Hour)}
+	})
+	for i := 0; i < 3; i++ {
+		if code, body := get(t, tun.URL); code != http.StatusOK {
+			t.Fatalf("visit %d through the proxy = %d %s", i, code, body)
+		}
+	}
+}
+
+// openEdge opens a tunnel serving h on srv behind basic auth, which puts it
+// on the HTTP edge, closed at the end of the test. The URL it returns
+// carries the credentials.
+func openEdge(t *testing.T, srv *gottest.Server, h http.Handler) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ln, err := tunnel.Listen(ctx, tunnel.Config{ControlAddr: srv.ControlAddr, DataAddr: srv.DataAddr, Auth: "user:pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: h}
+	go func() { _ = hs.Serve(ln) }()
+	t.Cleanup(func() { _ = hs.Close() })
+	return "http://user:pass@" + ln.Addr().String()
+}
+
+// openEcho opens a tunnel on srv that echoes raw bytes, closed at the end
+// of the test, and returns its public address.
+func openEcho(t *testing.T, srv *gottest.Server) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ln, err := tunnel.Listen(ctx, tunnel.Config{ControlAddr: srv.ControlAddr, DataAddr: srv.DataAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// echo sends a line of raw TCP to addr and returns what comes back.
+func echo(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "SSH-2.0-test\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	return line
+}
+
+func TestAccessLogKeepsRawTunnels(t *testing.T) {
+	srv, _ := openTunnel(t, hello, func(s *server.Server) {
+		s.AccessLog.Writer = io.Discard
+	})
+	if got := echo(t, openEcho(t, srv)); got != "SSH-2.0-test\r\n" {
+		t.Fatalf("raw tunnel echoed %q", got)
+	}
+}
+
+func TestTraceInjectOnlyOnEdge(t *testing.T) {
+	srv, _ := openTunnel(t, hello, func(s *server.Server) {
+		s.Tracer = tracing.New("test", nil, 1)
+		s.TraceInject = true
+	})
+	if got := echo(t, openEcho(t, srv)); got != "SSH-2.0-test\r\n" {
+		t.Fatalf("raw tunnel echoed %q", got)
+	}
+	url := openEdge(t, srv, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Traceparent"))
+	}))
+	if code, body := get(t, url); code != http.StatusOK {
+		t.Fatalf("edge tunnel = %d %s", code, body)
+	} else if _, ok := tracing.ParseTraceparent(body); !ok {
+		t.Fatalf("edge request carried traceparent %q", body)
+	}
+}
+
+func TestEdgeIdleTimeoutCoversRequestBodies(t *testing.T) {
+	srv, _ := openTunnel(t, hello, func(s *server.Server) {
+		s.Timeouts.Idle = 200 * time.Millisecond
+	})
+	url := openEdge(t, srv, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+	}))
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://user:pass@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Send the headers, then stall instead of sending the promised body.
+	_, _ = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nAuthorization: Basic dXNlcjpwYXNz\r\nContent-Length: 10\r\n\r\n")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("stalled upload still open: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/logging"
+	"github.com/HeyRistaa/got/internal/protocol/control"
+)
+
+// trafficStats counts a tunnel's visitor traffic for tunnel_stats messages.
+// Methods are safe on a nil *trafficStats.
+type trafficStats struct {
+	active, total, requests, errors atomic.Int64
+	bytesIn, bytesOut               atomic.Int64
+
+	mu     sync.Mutex
+	lastIP string
+}
+
+func (st *trafficStats) visitor(ip string) {
+	if st == nil || ip == "" {
+		return
+	}
+	st.mu.Lock()
+	st.lastIP = ip
+	st.mu.Unlock()
+}
+
+func (st *trafficStats) fail() {
+	if st != nil {
+		st.errors.Add(1)
+	}
+}
+
+// track counts conn as an open visitor connection until it is closed, along
+// with the bytes it carries.
+func (st *trafficStats) track(conn net.Conn) net.Conn {
+	if st == nil {
+		return conn
+	}
+	st.active.Add(1)
+	st.total.Add(1)
+	return &statsConn{Conn: conn, st: st}
+}
+
+func (st *trafficStats) snapshot(tunnelID string) control.TunnelStats {
+	st.mu.Lock()
+	lastIP := st.lastIP
+	st.mu.Unlock()
+	return control.TunnelStats{
+		Type:          "tunnel_stats",
+		TunnelID:      tunnelID,
+		ActiveConns:   st.active.Load(),
+		TotalConns:    st.total.Load(),
+		Requests:      st.requests.Load(),
+		BytesIn:       st.bytesIn.Load(),
+		BytesOut:      st.bytesOut.Load(),
+		Errors:        st.errors.Load(),
+		LastVisitorIP: lastIP,
+	}
+}
+
+// statsConn is a visitor connection counted in trafficStats
+type statsConn struct {
+	net.Conn
+	st     *trafficStats
+	closed atomic.Bool
+}
+
+func (c *statsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.st.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.st.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func (c *statsConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.st.active.Add(-1)
+	}
+	return c.Conn.Close()
+}
+
+// statsListener tracks every connection the edge accepts.
+type statsListener struct {
+	net.Listener
+	st *trafficStats
+}
+
+func (l *statsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.st.track(conn), nil
+}
+
+// countRequests records each edge request's visitor and any 5xx response.
+func (s *Server) countRequests(st *trafficStats, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st.requests.Add(1)
+		st.visitor(s.visitorIP(r).String())
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status >= 500 {
+			st.fail()
+		}
+	})
+}
+
+// tunnelStats returns the traffic counters of tunnelID, or nil once it is
+// gone.
+func (s *Server) tunnelStats(tunnelID string) *trafficStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t := s.tunnels[tunnelID]; t != nil {
+		return t.stats
+	}
+	return nil
+}
+
+// pushStats sends the tunnel's counters to its client every StatsInterval
+// while they change, until ctx is done.
+func (s *Server) pushStats(ctx context.Context, t *tunnelInfo) {
+	ticker := time.NewTicker(s.StatsInterval)
+	defer ticker.Stop()
+	var last control.TunnelStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cur := t.stats.snapshot(t.tunnel.ID)
+		if cur == last {
+			continue
+		}
+		if err := control.WriteJSONLine(t.ctlConn, cur); err != nil {
+			t.log.Debug("write tunnel_stats", logging.Err(err))
+			return
+		}
+		last = cur
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/protocol/control"
+	"github.com/HeyRistaa/got/internal/tunnel"
+)
+
+func TestTrafficStats(t *testing.T) {
+	st := &trafficStats{}
+	visitor, peer := net.Pipe()
+	defer peer.Close()
+	conn := st.track(visitor)
+	go func() {
+		_, _ = peer.Write([]byte("hello"))
+		_, _ = io.ReadFull(peer, make([]byte, 3))
+	}()
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hey")); err != nil {
+		t.Fatal(err)
+	}
+	st.visitor("198.51.100.7")
+	st.visitor("")
+	st.fail()
+
+	want := control.TunnelStats{
+		Type: "tunnel_stats", TunnelID: "t1",
+		ActiveConns: 1, TotalConns: 1, BytesIn: 5, BytesOut: 3, Errors: 1,
+		LastVisitorIP: "198.51.100.7",
+	}
+	if got := st.snapshot("t1"); got != want {
+		t.Fatalf("snapshot = %+v, want %+v", got, want)
+	}
+	conn.Close()
+	conn.Close()
+	if got := st.snapshot("t1"); got.ActiveConns != 0 || got.TotalConns != 1 {
+		t.Fatalf("after close = %+v, want no active connections", got)
+	}
+
+	// Tunnels without stats pass connections through untouched.
+	var none *trafficStats
+	if got := none.track(peer); got != peer {
+		t.Fatal("nil stats wrapped the connection")
+	}
+	none.visitor("198.51.100.7")
+	none.fail()
+}
+
+func TestCountRequests(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	st := &trafficStats{}
+	h := s.countRequests(st, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	for _, path := range []string{"/", "/broken", "/"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "203.0.113.9:4000"
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if got := st.snapshot("t1"); got.Requests != 3 || got.Errors != 1 || got.LastVisitorIP != "203.0.113.9" {
+		t.Fatalf("snapshot = %+v", got)
+	}
+}
+
+func TestPushStats(t *testing.T) {
+	s := New("", "", "127.0.0.1")
+	s.StatsInterval = 5 * time.Millisecond
+	ctl, client := net.Pipe()
+	defer client.Close()
+	info := &tunnelInfo{tunnel: &tunnel.Tunnel{ID: "t1"}, ctlConn: ctl, stats: &trafficStats{}, log: slog.Default()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.pushStats(ctx, info)
+		close(done)
+	}()
+
+	br := bufio.NewReader(client)
+	next := func() (control.TunnelStats, error) {
+		var msg control.TunnelStats
+		_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		return msg, control.ReadJSONLine(br, &msg)
+	}
+	// The client gets the initial counters once, then nothing while the
+	// tunnel is idle.
+	if msg, err := next(); err != nil || msg.TunnelID != "t1" || msg.TotalConns != 0 {
+		t.Fatalf("first tunnel_stats = %+v, %v", msg, err)
+	}
+	if msg, err := next(); err == nil {
+		t.Fatalf("idle tunnel was sent %+v", msg)
+	}
+
+	info.stats.requests.Add(2)
+	info.stats.bytesOut.Add(100)
+	msg, err := next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "tunnel_stats" || msg.TunnelID != "t1" || msg.Requests != 2 || msg.BytesOut != 100 {
+		t.Fatalf("tunnel_stats = %+v", msg)
+	}
+	// Unchanged counters are not sent again.
+	if msg, err := next(); err == nil {
+		t.Fatalf("unchanged stats sent again: %+v", msg)
+	}
+
+	info.stats.fail()
+	if msg, err := next(); err != nil || msg.Errors != 1 || msg.Requests != 2 {
+		t.Fatalf("tunnel_stats after an error = %+v, %v", msg, err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushStats kept running after its context ended")
+	}
+}
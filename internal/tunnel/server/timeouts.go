@@ -0,0 +1,232 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts bound how long connections may sit on the server doing nothing.
+// Zero values disable the corresponding timeout.
+type Timeouts struct {
+	// Handshake is how long a new control connection has to send
+	// open_tunnel and a new data connection to send data_init. On HTTP edge
+	// tunnels it also bounds reading request headers.
+	Handshake time.Duration
+
+	// Idle closes a visitor connection after no bytes have moved in either
+	// direction for this long. Edge tunnels also use it as the keep-alive
+	// idle timeout, and apply it to their data connections.
+	Idle time.Duration
+
+	// MaxLifetime closes a visitor connection this long after it was
+	// bridged or accepted by the edge, however busy it is. It also bounds
+	// reading a request on the edge.
+	MaxLifetime time.Duration
+
+	// KeepAlive is the TCP keep-alive period for accepted connections on
+	// every listener. Zero keeps Go's default, negative disables keep-alives.
+	KeepAlive time.Duration
+}
+
+// DefaultTimeouts gives peers ten seconds to complete a handshake. Idle
+// visitor connections are left open, since quiet WebSocket, SSH and
+// database sessions are normal.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Handshake: 10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+// deadline returns now+d, or the zero time (no deadline) when d is zero.
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// keepAliveListener applies the TCP keep-alive setting to accepted conns.
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+// withKeepAlive wraps ln so accepted conns use the configured keep-alive.
+func (s *Server) withKeepAlive(ln net.Listener) net.Listener {
+	if s.Timeouts.KeepAlive == 0 {
+		return ln
+	}
+	return &keepAliveListener{Listener: ln, period: s.Timeouts.KeepAlive}
+}
+
+func (l *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if l.period < 0 {
+			_ = tc.SetKeepAlive(false)
+		} else {
+			_ = tc.SetKeepAlive(true)
+			_ = tc.SetKeepAlivePeriod(l.period)
+		}
+	}
+	return conn, nil
+}
+
+// pipeDeadlines tracks activity on both halves of a piped connection so that
+// a quiet direction is not closed while the other one is busy.
+type pipeDeadlines struct {
+	idle time.Duration
+	end  time.Time // max lifetime; zero means none
+
+	last atomic.Int64 // unix nanos of the last transfer in either direction
+}
+
+func newPipeDeadlines(t Timeouts) *pipeDeadlines {
+	if t.Idle <= 0 && t.MaxLifetime <= 0 {
+		return nil
+	}
+	d := &pipeDeadlines{idle: t.Idle, end: deadline(t.MaxLifetime)}
+	d.touch()
+	return d
+}
+
+func (d *pipeDeadlines) touch() { d.last.Store(time.Now().UnixNano()) }
+
+// next returns the read deadline for a copy loop: the idle deadline counted
+// from the last activity on either side, capped by the max lifetime.
+func (d *pipeDeadlines) next() time.Time {
+	var t time.Time
+	if d.idle > 0 {
+		t = time.Unix(0, d.last.Load()).Add(d.idle)
+	}
+	if !d.end.IsZero() && (t.IsZero() || d.end.Before(t)) {
+		t = d.end
+	}
+	return t
+}
+
+// expired reports whether a read that timed out should end the pipe, as
+// opposed to the other direction having kept the connection alive.
+func (d *pipeDeadlines) expired(err error) bool {
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	return !time.Now().Before(d.next())
+}
+
+// deadlineConn applies the idle and max lifetime timeouts to a single
+// connection, for the HTTP edge where visitor and client connections are not
+// piped in pairs. Deadlines set by its user, such as http.Server's, still
+// apply; whichever comes first ends a blocked read or write.
+type deadlineConn struct {
+	net.Conn
+	d *pipeDeadlines
+
+	mu              sync.Mutex
+	readDL, writeDL time.Time // set by the user of the conn
+}
+
+// withDeadlines wraps conn with the idle and max lifetime timeouts, if any.
+func (s *Server) withDeadlines(conn net.Conn) net.Conn {
+	d := newPipeDeadlines(s.Timeouts)
+	if d == nil {
+		return conn
+	}
+	return &deadlineConn{Conn: conn, d: d}
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	for {
+		theirs := c.userDeadline(&c.readDL)
+		_ = c.Conn.SetReadDeadline(earliest(c.d.next(), theirs))
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.d.touch()
+		}
+		if n == 0 && c.retry(err, theirs) {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *deadlineConn) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		theirs := c.userDeadline(&c.writeDL)
+		_ = c.Conn.SetWriteDeadline(earliest(c.d.next(), theirs))
+		n, err := c.Conn.Write(p)
+		written += n
+		p = p[n:]
+		if n > 0 {
+			c.d.touch()
+		}
+		if err != nil && !c.retry(err, theirs) {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// retry reports whether err is our own idle deadline passing while the
+// connection is still in use and the user's deadline has not passed.
+func (c *deadlineConn) retry(err error, theirs time.Time) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded) && !c.d.expired(err) &&
+		(theirs.IsZero() || time.Now().Before(theirs))
+}
+
+func (c *deadlineConn) userDeadline(dl *time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *dl
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDL, c.writeDL = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(earliest(c.d.next(), t))
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDL = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(earliest(c.d.next(), t))
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDL = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(earliest(c.d.next(), t))
+}
+
+// earliest returns the earlier of two deadlines, where zero means none.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// deadlineListener wraps accepted conns with Server.withDeadlines.
+type deadlineListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *deadlineListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.s.withDeadlines(conn), nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func testDeadlineConn(t *testing.T, to Timeouts) (net.Conn, net.Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	s := &Server{Timeouts: to}
+	return s.withDeadlines(a), b
+}
+
+// drain reads from c until it fails.
+func drain(c net.Conn) {
+	buf := make([]byte, 1024)
+	for {
+		if _, err := c.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+func TestDeadlineConnIdle(t *testing.T) {
+	c, _ := testDeadlineConn(t, Timeouts{Idle: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read on an idle conn = %v, want a deadline error", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("idle conn closed after %v", d)
+	}
+}
+
+func TestDeadlineConnWritesKeepReadsAlive(t *testing.T) {
+	c, peer := testDeadlineConn(t, Timeouts{Idle: 50 * time.Millisecond})
+	go drain(peer)
+	go func() {
+		for range 10 {
+			_, _ = c.Write([]byte("x"))
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, _ = peer.Write([]byte("y"))
+	}()
+	// Nothing arrives for about 200ms, but the conn is busy the other way.
+	buf := make([]byte, 1)
+	if _, err := c.Read(buf); err != nil || buf[0] != 'y' {
+		t.Fatalf("Read = %q, %v; want y", buf, err)
+	}
+}
+
+func TestDeadlineConnLifetime(t *testing.T) {
+	c, peer := testDeadlineConn(t, Timeouts{MaxLifetime: 100 * time.Millisecond})
+	go drain(peer)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := c.Write([]byte("x")); err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("Write = %v, want a deadline error", err)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("busy conn outlived its max lifetime")
+		}
+	}
+}
+
+func TestDeadlineConnKeepsUserDeadline(t *testing.T) {
+	c, _ := testDeadlineConn(t, Timeouts{Idle: time.Hour})
+	_ = c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read past the user's deadline = %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/HeyRistaa/got/internal/tracing"
+)
+
+// traceHeader is the W3C trace context header
+const traceHeader = "traceparent"
+
+// traceRequest records a server span per request through the edge,
+// continuing the visitor's traceparent if it sent one. The span rides in the
+// request context to dialClient and to the proxy's Rewrite.
+func (s *Server) traceRequest(tunnelID string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := tracing.ParseTraceparent(r.Header.Get(traceHeader))
+		span := s.Tracer.Start(r.Method, tracing.KindServer, parent)
+		defer span.End()
+		span.SetAttr("got.tunnel_id", tunnelID)
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("url.path", r.URL.Path)
+		span.SetAttr("server.address", r.Host)
+		span.SetAttr("client.address", s.visitorIP(r).String())
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(tracing.ContextWith(r.Context(), span)))
+		if r.Header.Get("Upgrade") != "" && sw.status == 0 {
+			sw.status = http.StatusSwitchingProtocols
+		}
+		span.SetAttr("http.response.status_code", sw.status)
+		span.SetAttr("http.response.body.size", sw.bytes)
+		if sw.status >= 500 {
+			span.RecordError(fmt.Errorf("HTTP %d", sw.status))
+		}
+	})
+}
+
+// injectTraceparent sets the outgoing request's traceparent to the span
+// handling it, so the local app's own spans join the trace.
+func injectTraceparent(out, in *http.Request) {
+	if tp := tracing.FromContext(in.Context()).Traceparent(); tp != "" {
+		out.Header.Set(traceHeader, tp)
+		out.Header.Del("tracestate")
+	}
+}
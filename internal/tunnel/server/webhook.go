@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/logging"
+)
+
+// Webhook event types
+const (
+	WebhookTunnelOpened  = "tunnel.opened"
+	WebhookTunnelClosed  = "tunnel.closed"
+	WebhookHealthFailed  = "health.failed"  // a tunnel reached its health check failure threshold
+	WebhookQuotaExceeded = "quota.exceeded" // a client used up a transfer quota
+	WebhookAbuseFlagged  = "abuse.flagged"  // a tunnel served a phishing path, see AbuseRules
+)
+
+// WebhookEventTypes lists every webhook event type.
+var WebhookEventTypes = []string{WebhookTunnelOpened, WebhookTunnelClosed, WebhookHealthFailed, WebhookQuotaExceeded, WebhookAbuseFlagged}
+
+// Webhook is an endpoint that receives events as signed JSON POSTs. With a
+// Secret, each request carries X-Got-Signature: sha256=<hex HMAC-SHA256 of
+// "<X-Got-Timestamp>.<body>">.
+type Webhook struct {
+	URL    string
+	Secret string
+	Events []string // event types to send; empty sends all
+}
+
+func (w *Webhook) wants(typ string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, typ)
+}
+
+// Webhooks configures event delivery. Failed deliveries are retried with
+// exponential backoff; events that still cannot be delivered, or that find
+// the endpoint's queue full, are appended to DeadLetterFile.
+type Webhooks struct {
+	Endpoints      []Webhook
+	MaxAttempts    int           // deliveries tried per event
+	Backoff        time.Duration // wait after the first failure, doubled after each
+	Timeout        time.Duration // per delivery attempt
+	DeadLetterFile string        // JSONL of undeliverable events; empty drops them
+}
+
+// DefaultWebhooks tries each event 5 times over about 15 seconds.
+func DefaultWebhooks() Webhooks {
+	return Webhooks{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// WebhookEvent is the JSON body POSTed to webhooks
+type WebhookEvent struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	TunnelID string    `json:"tunnel_id"`
+	Host     string    `json:"host,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	ClientIP string    `json:"client_ip,omitempty"`
+	Reason   string    `json:"reason,omitempty"`  // tunnel.closed: see the Close* constants
+	Message  string    `json:"message,omitempty"` // health.failed, quota.exceeded and abuse.flagged
+}
+
+// Webhook delivery limits
+const (
+	webhookQueueSize  = 1024
+	maxWebhookBackoff = time.Minute
+)
+
+// webhookSender delivers events to each endpoint in order from its own
+// queue, so a slow endpoint does not hold up the others.
+type webhookSender struct {
+	s      *Server
+	client *http.Client
+	queues []chan WebhookEvent
+
+	mu     sync.Mutex
+	closed bool
+	stop   chan struct{} // closed at shutdown to cut retries short
+	wg     sync.WaitGroup
+
+	deadMu sync.Mutex
+}
+
+func newWebhookSender(s *Server) *webhookSender {
+	w := &webhookSender{
+		s:      s,
+		client: &http.Client{Timeout: s.Webhooks.Timeout},
+		stop:   make(chan struct{}),
+	}
+	for i := range s.Webhooks.Endpoints {
+		q := make(chan WebhookEvent, webhookQueueSize)
+		w.queues = append(w.queues, q)
+		w.wg.Add(1)
+		go w.run(&s.Webhooks.Endpoints[i], q)
+	}
+	return w
+}
+
+// notify queues e for every endpoint that wants it.
+func (w *webhookSender) notify(e WebhookEvent) {
+	if w == nil {
+		return
+	}
+	e.ID = randomID()
+	e.Time = time.Now().UTC()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	for i, q := range w.queues {
+		ep := &w.s.Webhooks.Endpoints[i]
+		if !ep.wants(e.Type) {
+			continue
+		}
+		select {
+		case q <- e:
+		default:
+			w.deadLetter(ep, e, 0, errors.New("queue full"))
+		}
+	}
+}
+
+// shutdown stops accepting events and gives queued ones one attempt each,
+// waiting up to timeout for them.
+func (w *webhookSender) shutdown(timeout time.Duration) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.closed = true
+	for _, q := range w.queues {
+		close(q)
+	}
+	w.mu.Unlock()
+	close(w.stop)
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (w *webhookSender) run(ep *Webhook, q <-chan WebhookEvent) {
+	defer w.wg.Done()
+	for e := range q {
+		w.deliver(ep, e)
+	}
+}
+
+// deliver posts e to ep, retrying with backoff, and dead-letters it once
+// attempts run out or the endpoint rejects it outright.
+func (w *webhookSender) deliver(ep *Webhook, e WebhookEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	cfg := &w.s.Webhooks
+	backoff := cfg.Backoff
+	attempts := max(cfg.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ep, e, body)
+		if err == nil {
+			return
+		}
+		log := w.s.logger().With("url", ep.URL, "event", e.Type, logging.TunnelID, e.TunnelID)
+		if !retry || attempt >= attempts {
+			log.Warn("webhook delivery failed", "attempts", attempt, logging.Err(err))
+			w.deadLetter(ep, e, attempt, err)
+			return
+		}
+		log.Debug("webhook delivery failed, retrying", "attempt", attempt, "backoff", backoff, logging.Err(err))
+		select {
+		case <-w.stop:
+			w.deadLetter(ep, e, attempt, fmt.Errorf("server shutting down: %w", err))
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxWebhookBackoff)
+	}
+}
+
+// post makes one delivery attempt. retry reports whether a failure may be
+// temporary: network errors, 408, 429 and 5xx.
+func (w *webhookSender) post(ep *Webhook, e WebhookEvent, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "got-webhook")
+	req.Header.Set("X-Got-Event", e.Type)
+	req.Header.Set("X-Got-Delivery", e.ID)
+	req.Header.Set("X-Got-Timestamp", ts)
+	if ep.Secret != "" {
+		req.Header.Set("X-Got-Signature", "sha256="+webhookSignature(ep.Secret, ts, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("status %s", resp.Status)
+}
+
+// webhookSignature is the hex HMAC-SHA256 of "<timestamp>.<body>" under secret.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter appends an undeliverable event to the dead-letter file.
+func (w *webhookSender) deadLetter(ep *Webhook, e WebhookEvent, attempts int, cause error) {
+	path := w.s.Webhooks.DeadLetterFile
+	if path == "" {
+		return
+	}
+	line, err := json.Marshal(struct {
+		Time     time.Time    `json:"time"`
+		URL      string       `json:"url"`
+		Attempts int          `json:"attempts"`
+		Error    string       `json:"error"`
+		Event    WebhookEvent `json:"event"`
+	}{time.Now().UTC(), ep.URL, attempts, cause.Error(), e})
+	if err != nil {
+		return
+	}
+	w.deadMu.Lock()
+	defer w.deadMu.Unlock()
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		var f *os.File
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err == nil {
+			_, err = f.Write(append(line, '\n'))
+			f.Close()
+		}
+	}
+	if err != nil {
+		w.s.logger().Error("webhook dead letter", logging.Err(err))
+	}
+}
+
+// notifyTunnel sends a webhook event about t.
+func (s *Server) notifyTunnel(t *tunnelInfo, typ, reason, message string) {
+	s.webhooks.notify(WebhookEvent{
+		Type:     typ,
+		TunnelID: t.tunnel.ID,
+		Host:     t.tunnel.Host,
+		ClientID: t.clientID,
+		ClientIP: t.clientIP,
+		Reason:   reason,
+		Message:  message,
+	})
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds, failing the test after 5 seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// testWebhooks starts a sender delivering to a single endpoint served by h.
+func testWebhooks(t *testing.T, h http.HandlerFunc, configure func(*Webhooks)) *webhookSender {
+	t.Helper()
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	s := &Server{Webhooks: Webhooks{
+		Endpoints:   []Webhook{{URL: ts.URL, Secret: "s3cret"}},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Timeout:     5 * time.Second,
+	}}
+	if configure != nil {
+		configure(&s.Webhooks)
+	}
+	return newWebhookSender(s)
+}
+
+func TestWebhookSignature(t *testing.T) {
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	w := testWebhooks(t, func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- b
+	}, nil)
+	w.notify(WebhookEvent{Type: WebhookTunnelOpened, TunnelID: "t1"})
+	w.shutdown(5 * time.Second)
+
+	r, body := <-got, <-bodies
+	want := "sha256=" + webhookSignature("s3cret", r.Header.Get("X-Got-Timestamp"), body)
+	if sig := r.Header.Get("X-Got-Signature"); sig != want {
+		t.Fatalf("X-Got-Signature = %q, want %q", sig, want)
+	}
+	var e WebhookEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != WebhookTunnelOpened || e.TunnelID != "t1" || e.ID != r.Header.Get("X-Got-Delivery") {
+		t.Fatalf("event = %+v, delivery %q", e, r.Header.Get("X-Got-Delivery"))
+	}
+	// The signature covers the timestamp, so a replayed body with a new
+	// timestamp does not verify.
+	if webhookSignature("s3cret", "1", body) == strings.TrimPrefix(want, "sha256=") {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookRetriesTemporaryFailures(t *testing.T) {
+	var calls atomic.Int32
+	w := testWebhooks(t, func(rw http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}, nil)
+	w.notify(WebhookEvent{Type: WebhookTunnelClosed, TunnelID: "t1"})
+	waitFor(t, func() bool { return calls.Load() >= 3 })
+	w.shutdown(5 * time.Second)
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("endpoint called %d times, want 3", n)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status int
+		calls  int32
+	}{
+		"attempts run out":    {http.StatusInternalServerError, 3},
+		"permanent rejection": {http.StatusBadRequest, 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dead := filepath.Join(t.TempDir(), "dead.jsonl")
+			var calls atomic.Int32
+			w := testWebhooks(t, func(rw http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				rw.WriteHeader(tc.status)
+			}, func(wh *Webhooks) { wh.DeadLetterFile = dead })
+			w.notify(WebhookEvent{Type: WebhookHealthFailed, TunnelID: "t1"})
+			// Shutting down cuts retries short, so wait for the dead letter.
+			waitFor(t, func() bool {
+				_, err := os.Stat(dead)
+				return err == nil
+			})
+			w.shutdown(5 * time.Second)
+
+			if n := calls.Load(); n != tc.calls {
+				t.Fatalf("endpoint called %d times, want %d", n, tc.calls)
+			}
+			b, err := os.ReadFile(dead)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var line struct {
+				Attempts int          `json:"attempts"`
+				Event    WebhookEvent `json:"event"`
+			}
+			if err := json.Unmarshal(b, &line); err != nil {
+				t.Fatalf("dead letter %q: %v", b, err)
+			}
+			if line.Attempts != int(tc.calls) || line.Event.Type != WebhookHealthFailed {
+				t.Fatalf("dead letter = %+v", line)
+			}
+		})
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	got := make(chan string, 2)
+	w := testWebhooks(t, func(rw http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-Got-Event")
+	}, func(wh *Webhooks) { wh.Endpoints[0].Events = []string{WebhookTunnelClosed} })
+	w.notify(WebhookEvent{Type: WebhookTunnelOpened})
+	w.notify(WebhookEvent{Type: WebhookTunnelClosed})
+	w.shutdown(5 * time.Second)
+	close(got)
+	var types []string
+	for typ := range got {
+		types = append(types, typ)
+	}
+	if len(types) != 1 || types[0] != WebhookTunnelClosed {
+		t.Fatalf("delivered %v, want only %s", types, WebhookTunnelClosed)
+	}
+}
@@ -0,0 +1,145 @@
+// Package tunnel opens got tunnels from Go programs.
+//
+// Listen returns a net.Listener whose Accept yields visitor connections
+// forwarded by the got server, so a program can serve HTTP (or any TCP
+// protocol) on a public URL without binding a local port:
+//
+//	ln, err := tunnel.Listen(ctx, tunnel.Config{Server: "tunnel.example.com"})
+//	if err != nil {
+//		return err
+//	}
+//	defer ln.Close()
+//	log.Printf("public URL: %s", ln.URL())
+//	http.Serve(ln, handler)
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/HeyRistaa/got/internal/tunnel/client"
+)
+
+// Config describes which server to open the tunnel on
+type Config struct {
+	// Server is the got server host. The control (4440) and data (4441)
+	// ports are derived from it unless ControlAddr/DataAddr are set.
+	Server string
+
+	ControlAddr string // optional control host:port override
+	DataAddr    string // optional data host:port override
+	ClientID    string // optional label reported to the server
+	Auth        string // optional "user:pass" visitors must supply (HTTP Basic)
+	Token       string // optional API token presented to the server
+
+	// Logger receives the client's errors; nil logs to slog.Default().
+	Logger *slog.Logger
+}
+
+func (c Config) addrs() (ctl, data string, err error) {
+	ctl, data = c.ControlAddr, c.DataAddr
+	if ctl == "" || data == "" {
+		if c.Server == "" {
+			return "", "", errors.New("tunnel: no server configured")
+		}
+	}
+	if ctl == "" {
+		ctl = net.JoinHostPort(c.Server, "4440")
+	}
+	if data == "" {
+		data = net.JoinHostPort(c.Server, "4441")
+	}
+	return ctl, data, nil
+}
+
+// Listener is a net.Listener backed by a tunnel on a got server
+type Listener struct {
+	sess  *client.Session
+	ready chan struct{} // closed once sess is set
+	conns chan net.Conn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Listen opens a tunnel and returns a listener for its visitor connections.
+// The tunnel stays open until the listener is closed or the server drops it.
+func Listen(ctx context.Context, cfg Config) (*Listener, error) {
+	ctlAddr, dataAddr, err := cfg.addrs()
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		ready:  make(chan struct{}),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	c := client.New(ctlAddr, dataAddr, "", cfg.ClientID, "")
+	c.Handler = l.deliver
+	c.Auth = cfg.Auth
+	c.Token = cfg.Token
+	c.Logger = cfg.Logger
+	sess, err := c.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tunnel: %w", err)
+	}
+	l.sess = sess
+	close(l.ready)
+	return l, nil
+}
+
+// deliver hands a visitor connection to Accept. Open serves connections
+// before it returns, so deliver first waits for the session to be set.
+func (l *Listener) deliver(conn net.Conn) {
+	<-l.ready
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	case <-l.sess.Done():
+		conn.Close()
+	}
+}
+
+// Accept waits for and returns the next visitor connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-l.sess.Done():
+		return nil, errors.New("tunnel: control connection closed by server")
+	}
+}
+
+// Close closes the tunnel. Connections already accepted are not affected.
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.sess.Close()
+	})
+	return err
+}
+
+// Addr returns the public address of the tunnel on the server.
+func (l *Listener) Addr() net.Addr { return publicAddr(l.sess.Opened.PublicAddr) }
+
+// URL returns the public URL of the tunnel.
+func (l *Listener) URL() string { return l.sess.URL() }
+
+// PublicHost returns the hostname routed to this tunnel, if any.
+func (l *Listener) PublicHost() string { return l.sess.Opened.PublicHost }
+
+// TunnelID returns the server-assigned tunnel ID.
+func (l *Listener) TunnelID() string { return l.sess.Opened.TunnelID }
+
+type publicAddr string
+
+func (a publicAddr) Network() string { return "tcp" }
+func (a publicAddr) String() string  { return string(a) }
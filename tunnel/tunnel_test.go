@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HeyRistaa/got/internal/protocol/control"
+)
+
+// eagerServer is a minimal got server that sends a conn_request in the same
+// write as tunnel_opened, so the client serves it before Open returns.
+func eagerServer(t *testing.T) (ctlAddr, dataAddr string) {
+	t.Helper()
+	ctl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ctl.Close(); data.Close() })
+
+	go func() {
+		for {
+			conn, err := ctl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var open control.OpenTunnel
+				if control.ReadJSONLine(bufio.NewReader(conn), &open) != nil {
+					return
+				}
+				var buf bytes.Buffer
+				_ = control.WriteJSONLine(&buf, control.TunnelOpened{Type: "tunnel_opened", TunnelID: "t1", PublicAddr: "127.0.0.1:1"})
+				_ = control.WriteJSONLine(&buf, control.ConnRequest{Type: "conn_request", TunnelID: "t1", ConnID: "c1"})
+				_, _ = conn.Write(buf.Bytes())
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	go func() {
+		for {
+			conn, err := data.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				var init control.DataInit
+				if control.ReadJSONLine(bufio.NewReader(conn), &init) != nil {
+					conn.Close()
+					return
+				}
+				_, _ = conn.Write([]byte("hello"))
+				conn.Close()
+			}()
+		}
+	}()
+	return ctl.Addr().String(), data.Addr().String()
+}
+
+func TestListenConnBeforeOpenReturns(t *testing.T) {
+	ctlAddr, dataAddr := eagerServer(t)
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ln, err := Listen(ctx, Config{ControlAddr: ctlAddr, DataAddr: dataAddr})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b, err := io.ReadAll(conn)
+		if err != nil || string(b) != "hello" {
+			t.Fatalf("read %q, %v; want hello", b, err)
+		}
+		conn.Close()
+		ln.Close()
+	}
+}
+
+func TestListenHonorsContextWhenServerIsSilent(t *testing.T) {
+	ctl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.Close()
+	// Accept the control connection but never answer open_tunnel.
+	go func() {
+		conn, err := ctl.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = Listen(ctx, Config{ControlAddr: ctl.Addr().String(), DataAddr: "127.0.0.1:1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Listen = %v, want the context deadline", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("Listen returned after %v", d)
+	}
+}